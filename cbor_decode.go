@@ -0,0 +1,321 @@
+package tlog
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// CBORToJSON reads CBOR log records written with FormatCBOR from r and writes
+// them to w as JSON lines.
+//
+// Epoch timestamps (tag 1) are written in RFC 3339 format, embedded JSON
+// (tag 262) is copied as is and byte strings are base64 encoded.
+func CBORToJSON(r io.Reader, w io.Writer) error {
+	d := cborDecoder{r: bufio.NewReader(r), enc: encoder{buf: make([]byte, 0, 512)}}
+	bw := bufio.NewWriter(w)
+	for {
+		d.enc.buf = d.enc.buf[:0]
+		if _, err := d.r.Peek(1); err == io.EOF {
+			break
+		}
+		if err := d.decodeItem(0); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		d.enc.buf = append(d.enc.buf, '\n')
+		if _, err := bw.Write(d.enc.buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+var errCborBreak = errors.New("cbor: break")
+
+const cborMaxDepth = 64
+
+type cborDecoder struct {
+	r   *bufio.Reader
+	enc encoder
+	tmp []byte
+}
+
+func (d *cborDecoder) readHead() (major byte, info byte, v uint64, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b&0xe0, b&0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	case info == 31:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: invalid additional info %d", info)
+	}
+	var p [8]byte
+	if _, err = io.ReadFull(d.r, p[:n]); err != nil {
+		return 0, 0, 0, err
+	}
+	for i := 0; i < n; i++ {
+		v = v<<8 | uint64(p[i])
+	}
+	return major, info, v, nil
+}
+
+func (d *cborDecoder) readBytes(major byte, info byte, n uint64) ([]byte, error) {
+	d.tmp = d.tmp[:0]
+	if info != 31 {
+		if n > math.MaxInt32 {
+			return nil, errors.New("cbor: string too long")
+		}
+		if cap(d.tmp) < int(n) {
+			d.tmp = make([]byte, n)
+		}
+		d.tmp = d.tmp[:n]
+		_, err := io.ReadFull(d.r, d.tmp)
+		return d.tmp, err
+	}
+	// indefinite length, a sequence of definite length chunks
+	for {
+		m, cinfo, cn, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if m == cborMajorSimple && cinfo == 31 {
+			return d.tmp, nil
+		}
+		if m != major || cinfo == 31 || cn > math.MaxInt32 {
+			return nil, errors.New("cbor: invalid string chunk")
+		}
+		start := len(d.tmp)
+		d.tmp = append(d.tmp, make([]byte, cn)...)
+		if _, err = io.ReadFull(d.r, d.tmp[start:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *cborDecoder) decodeItem(depth int) error {
+	if depth > cborMaxDepth {
+		return errors.New("cbor: nesting too deep")
+	}
+	major, info, v, err := d.readHead()
+	if err != nil {
+		return err
+	}
+	e := &d.enc
+	switch major {
+	case cborMajorUint:
+		e.buf = strconv.AppendUint(e.buf, v, 10)
+	case cborMajorNegInt:
+		if v > math.MaxInt64 {
+			e.buf = append(e.buf, '-')
+			e.buf = strconv.AppendUint(e.buf, v+1, 10)
+		} else {
+			e.buf = strconv.AppendInt(e.buf, -1-int64(v), 10)
+		}
+	case cborMajorBytes:
+		b, err := d.readBytes(major, info, v)
+		if err != nil {
+			return err
+		}
+		e.buf = append(e.buf, '"')
+		start := len(e.buf)
+		e.buf = append(e.buf, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+		base64.StdEncoding.Encode(e.buf[start:], b)
+		e.buf = append(e.buf, '"')
+	case cborMajorText:
+		b, err := d.readBytes(major, info, v)
+		if err != nil {
+			return err
+		}
+		e.buf = append(e.buf, '"')
+		e.appendString(string(b))
+		e.buf = append(e.buf, '"')
+	case cborMajorArray:
+		e.buf = append(e.buf, '[')
+		for i := uint64(0); info == 31 || i < v; i++ {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err = d.decodeItem(depth + 1); err == errCborBreak && info == 31 {
+				if i > 0 {
+					e.buf = e.buf[:len(e.buf)-1]
+				}
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, ']')
+	case cborMajorMap:
+		e.buf = append(e.buf, '{')
+		for i := uint64(0); info == 31 || i < v; i++ {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err = d.decodeKey(depth + 1); err == errCborBreak && info == 31 {
+				if i > 0 {
+					e.buf = e.buf[:len(e.buf)-1]
+				}
+				break
+			} else if err != nil {
+				return err
+			}
+			e.buf = append(e.buf, ':')
+			if err = d.decodeItem(depth + 1); err != nil {
+				if err == errCborBreak {
+					err = errors.New("cbor: missing map value")
+				}
+				return err
+			}
+		}
+		e.buf = append(e.buf, '}')
+	case cborMajorTag:
+		return d.decodeTag(v, depth)
+	case cborMajorSimple:
+		return d.decodeSimple(info, v)
+	}
+	return nil
+}
+
+// JSON object keys must be strings
+func (d *cborDecoder) decodeKey(depth int) error {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if b[0]&0xe0 == cborMajorText || b[0] == cborBreak {
+		return d.decodeItem(depth)
+	}
+	start := len(d.enc.buf)
+	if err = d.decodeItem(depth); err != nil {
+		return err
+	}
+	d.enc.scratch = append(d.enc.scratch[:0], d.enc.buf[start:]...)
+	d.enc.buf = d.enc.buf[:start]
+	d.enc.buf = append(d.enc.buf, '"')
+	d.enc.appendString(string(d.enc.scratch))
+	d.enc.buf = append(d.enc.buf, '"')
+	return nil
+}
+
+func (d *cborDecoder) decodeTag(tag uint64, depth int) error {
+	e := &d.enc
+	switch tag {
+	case cborTagEpochDateTime:
+		major, info, v, err := d.readHead()
+		if err != nil {
+			return err
+		}
+		var t time.Time
+		switch {
+		case major == cborMajorUint:
+			t = time.Unix(int64(v), 0)
+		case major == cborMajorNegInt:
+			t = time.Unix(-1-int64(v), 0)
+		case major == cborMajorSimple && info >= 25 && info <= 27:
+			f := cborFloat(info, v)
+			sec, frac := math.Modf(f)
+			t = time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3)
+		default:
+			return errors.New("cbor: invalid epoch date/time")
+		}
+		e.buf = append(e.buf, '"')
+		e.buf = t.AppendFormat(e.buf, time.RFC3339Nano)
+		e.buf = append(e.buf, '"')
+		return nil
+	case cborTagEmbeddedJSON:
+		major, info, v, err := d.readHead()
+		if err != nil {
+			return err
+		}
+		if major != cborMajorBytes && major != cborMajorText {
+			return errors.New("cbor: invalid embedded json")
+		}
+		b, err := d.readBytes(major, info, v)
+		if err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			b = append(b, "null"...)
+		}
+		e.buf = append(e.buf, b...)
+		return nil
+	}
+	// Unknown tags (including tag 0) are transparent
+	return d.decodeItem(depth + 1)
+}
+
+func (d *cborDecoder) decodeSimple(info byte, v uint64) error {
+	e := &d.enc
+	switch info {
+	case 20:
+		e.buf = append(e.buf, "false"...)
+	case 21:
+		e.buf = append(e.buf, "true"...)
+	case 25, 26, 27:
+		f := cborFloat(info, v)
+		if info == 26 {
+			e.appendFloat(f, 32)
+		} else {
+			e.appendFloat(f, 64)
+		}
+	case 31:
+		return errCborBreak
+	default: // null, undefined and unassigned simple values
+		e.buf = append(e.buf, "null"...)
+	}
+	return nil
+}
+
+func cborFloat(info byte, v uint64) float64 {
+	switch info {
+	case 25:
+		return float16ToFloat64(uint16(v))
+	case 26:
+		return float64(math.Float32frombits(uint32(v)))
+	}
+	return math.Float64frombits(v)
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,61 @@
+package tlog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// coarseClock is updated by a background ticker, reading it is an atomic load
+// instead of a time.Now() call per line. See CoarseClock.
+type coarseClock struct {
+	nanos atomic.Int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newCoarseClock(resolution time.Duration) *coarseClock {
+	c := &coarseClock{done: make(chan struct{})}
+	c.nanos.Store(time.Now().UnixNano())
+	go c.run(resolution)
+	return c
+}
+func (c *coarseClock) run(resolution time.Duration) {
+	ticker := time.NewTicker(resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.nanos.Store(now.UnixNano())
+		}
+	}
+}
+func (c *coarseClock) now() time.Time {
+	return time.Unix(0, c.nanos.Load())
+}
+func (c *coarseClock) stop() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Pre-formatted `2023-07-14 21:08:20` for one second, only the millisecond
+// suffix is appended per line.
+type timeHeaderCache struct {
+	sec  int64
+	text []byte
+}
+
+func (e *encoder) appendCachedHumanReadableTime() {
+	sec := e.now.Unix()
+	if c := e.tl.timeCache.Load(); c != nil && c.sec == sec {
+		e.buf = append(e.buf, c.text...)
+		return
+	}
+	start := len(e.buf)
+	e.appendHumanReadableTime()
+	c := &timeHeaderCache{sec: sec, text: make([]byte, len(e.buf)-start)}
+	copy(c.text, e.buf[start:])
+	e.tl.timeCache.Store(c)
+}
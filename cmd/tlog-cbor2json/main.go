@@ -0,0 +1,39 @@
+// Command tlog-cbor2json converts log files written with tlog.FormatCBOR to
+// JSON lines.
+//
+//	tlog-cbor2json logs/tlog-2023-07-14.log > tlog.json
+//	tail -c +0 -f logs/tlog.log | tlog-cbor2json
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shaovie/tlog"
+)
+
+func main() {
+	files := os.Args[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := convert(name, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "tlog-cbor2json: %s: %s\n", name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func convert(name string, w io.Writer) error {
+	if name == "-" {
+		return tlog.CBORToJSON(os.Stdin, w)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tlog.CBORToJSON(f, w)
+}
@@ -0,0 +1,432 @@
+package tlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a TLog in a file, see NewFromConfig. The durations are
+// strings like "5s".
+type Config struct {
+	Format     string          `json:"format"`      // json, text, logfmt, console or cbor
+	Level      string          `json:"level"`       // "info", or overrides "db=debug, *=info", see ParseLevelOverrides
+	TimeFormat string          `json:"time_format"` // see configTimeFormats, else a time layout such as "2006-01-02 15:04"
+	TimeZone   string          `json:"time_zone"`   // e.g. "UTC", "Asia/Shanghai"
+	Writers    []WriterConfig  `json:"writers"`     // console if empty
+	Sampling   *SamplingConfig `json:"sampling"`
+	Redact     []RedactConfig  `json:"redact"`
+	Reload     string          `json:"reload"` // poll interval of the file, no reload if empty
+}
+
+// WriterConfig describes a writer of Config
+type WriterConfig struct {
+	Type   string `json:"type"`   // console, file, file_separate, post or splunk
+	Dir    string `json:"dir"`    // for file, file_separate
+	Prefix string `json:"prefix"` // for file, file_separate
+	Mode   string `json:"mode"`   // for file, file_separate: daily or append
+
+	URL string `json:"url"` // for post, splunk
+
+	// for splunk
+	Token         string `json:"token"`
+	Host          string `json:"host"`
+	Source        string `json:"source"`
+	SourceType    string `json:"source_type"`
+	Channel       string `json:"channel"`
+	BatchSize     int    `json:"batch_size"`
+	FlushInterval string `json:"flush_interval"`
+}
+
+// SamplingConfig describes the sampler of Config, a BurstSampler if Burst is
+// set (with a BasicSampler of Every after the burst), else a BasicSampler
+type SamplingConfig struct {
+	Every  uint32 `json:"every"`
+	Burst  uint32 `json:"burst"`
+	Period string `json:"period"`
+	Report string `json:"report"` // see SampleReport
+}
+
+// RedactConfig describes a RedactRule of Config, the values are masked by
+// MaskKeepLast(KeepLast) if KeepLast > 0, else by MaskAll
+type RedactConfig struct {
+	Keys        []string `json:"keys"`
+	KeyGlobs    []string `json:"key_globs"`
+	ValueRegexp string   `json:"value_regexp"`
+	KeepLast    int      `json:"keep_last"`
+}
+
+var configTimeFormats = map[string]int{
+	"datetime":     HumanReadableTime,
+	"datetime_ms":  HumanReadableTimeMs,
+	"unix":         UnixTimestamp,
+	"unix_ms":      UnixTimestampMs,
+	"unix_us":      UnixTimestampUs,
+	"unix_ns":      UnixTimestampNs,
+	"rfc3339":      RFC3339Time,
+	"rfc3339_ms":   RFC3339MsTime,
+	"rfc3339_nano": RFC3339NanoTime,
+}
+
+// isTimeLayout returns true if s has a digit element of the reference time,
+// a misspelled name of configTimeFormats such as "rfc3399" has none
+func isTimeLayout(s string) bool {
+	for _, ref := range []string{"2006", "01", "02", "15", "04", "05"} {
+		if strings.Contains(s, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+var configFormats = map[string]int{
+	"json":    FormatJson,
+	"text":    FormatText,
+	"logfmt":  FormatLogfmt,
+	"console": FormatConsole,
+	"cbor":    FormatCBOR,
+}
+
+// ConfigDecoder decodes a config file into v, the values must be the ones of
+// encoding/json (maps with string keys etc.), e.g. yaml.Unmarshal of
+// gopkg.in/yaml.v3
+type ConfigDecoder func(data []byte, v any) error
+
+var configDecoders = struct {
+	mtx sync.RWMutex
+	m   map[string]ConfigDecoder
+}{m: map[string]ConfigDecoder{".json": json.Unmarshal}}
+
+// RegisterConfigDecoder sets the decoder of the files with extension ext,
+// e.g. RegisterConfigDecoder(".yaml", yaml.Unmarshal). JSON is built in.
+func RegisterConfigDecoder(ext string, d ConfigDecoder) {
+	if !strings.HasPrefix(ext, ".") || d == nil {
+		panic("tlog:RegisterConfigDecoder param is illegal")
+	}
+	configDecoders.mtx.Lock()
+	defer configDecoders.mtx.Unlock()
+	configDecoders.m[strings.ToLower(ext)] = d
+}
+
+// Environment variables overriding the fields of Config
+var configEnv = []struct {
+	name  string
+	field func(c *Config) *string
+}{
+	{"TLOG_FORMAT", func(c *Config) *string { return &c.Format }},
+	{"TLOG_LEVEL", func(c *Config) *string { return &c.Level }},
+	{"TLOG_TIME_FORMAT", func(c *Config) *string { return &c.TimeFormat }},
+	{"TLOG_TIME_ZONE", func(c *Config) *string { return &c.TimeZone }},
+}
+
+// LoadConfig reads the config file with the decoder of its extension, then
+// applies the environment overrides TLOG_FORMAT, TLOG_LEVEL, TLOG_TIME_FORMAT
+// and TLOG_TIME_ZONE
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	configDecoders.mtx.RLock()
+	decode, ok := configDecoders.m[ext]
+	configDecoders.mtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("tlog: no config decoder for %q", ext)
+	}
+	// decoded generically then through JSON, so that the json tags (and
+	// unknown field errors) hold for every decoder
+	var v any
+	if err = decode(data, &v); err != nil {
+		return nil, fmt.Errorf("tlog: config %s: %w", path, err)
+	}
+	if data, err = json.Marshal(v); err != nil {
+		return nil, fmt.Errorf("tlog: config %s: %w", path, err)
+	}
+	c := &Config{}
+	d := json.NewDecoder(strings.NewReader(string(data)))
+	d.DisallowUnknownFields()
+	if err = d.Decode(c); err != nil {
+		return nil, fmt.Errorf("tlog: config %s: %w", path, err)
+	}
+	for _, env := range configEnv {
+		if v, ok := os.LookupEnv(env.name); ok {
+			*env.field(c) = v
+		}
+	}
+	return c, nil
+}
+
+// NewFromConfig creates a TLog from the config file path, see LoadConfig.
+// opts are applied after the config. If Config.Reload is set, the file is
+// polled and the changes of the writers and levels are applied without
+// dropping lines, the other changes need a restart.
+func NewFromConfig(path string, opts ...Option) (*TLog, error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var interval time.Duration
+	if len(c.Reload) != 0 {
+		if interval, err = configDuration("config reload", c.Reload); err != nil {
+			return nil, err
+		}
+	}
+	cfgOpts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.newWriter()
+	if err != nil {
+		return nil, err
+	}
+	sw := &swapWriter{w: w}
+	tl, err := NewE(append(append(cfgOpts, SetWriter(sw)), opts...)...)
+	if err != nil {
+		closeWriter(w)
+		return nil, err
+	}
+	if tl.writer != Writer(sw) { // replaced by opts, the writers of c are unused
+		closeWriter(w)
+		sw = nil
+		if interval > 0 {
+			tl.Warn().Msg("tlog: the writer is set by the options, the writers of the config are not reloaded")
+		}
+	}
+	if interval > 0 {
+		go tl.watchConfig(path, interval, c, sw)
+	}
+	return tl, nil
+}
+
+// Options returns the options of c but the writers
+func (c *Config) Options() (opts []Option, err error) {
+	if len(c.Format) != 0 {
+		f, ok := configFormats[strings.ToLower(c.Format)]
+		if !ok {
+			return nil, fmt.Errorf("tlog: unknown format %q", c.Format)
+		}
+		opts = append(opts, Format(f))
+	}
+	if len(c.Level) != 0 {
+		overrides, err := c.levelOverrides()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, func(o *Options) {
+			o.levelOverrides = overrides
+		})
+	}
+	if len(c.TimeFormat) != 0 {
+		if f, ok := configTimeFormats[strings.ToLower(c.TimeFormat)]; ok {
+			opts = append(opts, TimeFormat(f))
+		} else if isTimeLayout(c.TimeFormat) {
+			opts = append(opts, TimeLayout(c.TimeFormat))
+		} else {
+			return nil, &OptionError{Option: "TimeFormat", Err: fmt.Errorf("unknown time format %q", c.TimeFormat)}
+		}
+	}
+	if len(c.TimeZone) != 0 {
+		loc, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("tlog: time zone: %w", err)
+		}
+		opts = append(opts, TimeZone(loc))
+	}
+	if s := c.Sampling; s != nil {
+		var sampler Sampler = &BasicSampler{N: s.Every}
+		if s.Burst > 0 {
+			period, err := configDuration("sampling period", s.Period)
+			if err != nil {
+				return nil, err
+			}
+			next := sampler
+			if s.Every == 0 {
+				next = nil
+			}
+			sampler = &BurstSampler{Burst: s.Burst, Period: period, NextSampler: next}
+		}
+		opts = append(opts, SetSampler(sampler))
+		if len(s.Report) != 0 {
+			d, err := configDuration("sampling report", s.Report)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, SampleReport(d))
+		}
+	}
+	for _, r := range c.Redact {
+		rule := RedactRule{Keys: r.Keys, KeyGlobs: r.KeyGlobs}
+		if len(r.ValueRegexp) != 0 {
+			if rule.ValueRegexp, err = regexp.Compile(r.ValueRegexp); err != nil {
+				return nil, fmt.Errorf("tlog: redact: %w", err)
+			}
+		}
+		if r.KeepLast > 0 {
+			rule.Mask = MaskKeepLast(r.KeepLast)
+		}
+		opts = append(opts, Redact(rule))
+	}
+	return opts, nil
+}
+
+// levelOverrides parses c.Level, a single level is the "*" override
+func (c *Config) levelOverrides() (map[string]int, error) {
+	if strings.IndexByte(c.Level, '=') >= 0 {
+		return ParseLevelOverrides(c.Level)
+	}
+	mask, err := parseLevelMask(strings.TrimSpace(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"*": mask}, nil
+}
+
+// newWriter builds the writers of c, a console writer if there's none
+func (c *Config) newWriter() (Writer, error) {
+	if len(c.Writers) == 0 {
+		return NewWriteToConsole(), nil
+	}
+	var ws multiWriter
+	for _, wc := range c.Writers {
+		w, err := wc.newWriter()
+		if err != nil {
+			closeWriter(ws)
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	if len(ws) == 1 {
+		return ws[0], nil
+	}
+	return ws, nil
+}
+
+func (wc *WriterConfig) newWriter() (Writer, error) {
+	var opts []Option
+	if len(wc.Dir) != 0 {
+		opts = append(opts, LogDir(wc.Dir))
+	}
+	if len(wc.Prefix) != 0 {
+		opts = append(opts, LogFilePrefix(wc.Prefix))
+	}
+	switch wc.Mode {
+	case "":
+	case "daily":
+		opts = append(opts, FileStoreMode(DailySplit))
+	case "append":
+		opts = append(opts, FileStoreMode(AppendOneFile))
+	default:
+		return nil, fmt.Errorf("tlog: unknown file mode %q", wc.Mode)
+	}
+	if len(wc.URL) != 0 {
+		opts = append(opts, PostUrl(wc.URL))
+	}
+	switch wc.Type {
+	case "console":
+		return NewWriteToConsole(), nil
+	case "file":
+		return OpenFileMixed(opts...)
+	case "file_separate":
+		return OpenFileSeparate(opts...)
+	case "post":
+		w, err := OpenSimplePost(opts...)
+		if err != nil {
+			return nil, err // not a nil *WriteToSimplePost in a Writer
+		}
+		return w, nil
+	case "splunk":
+		opts = append(opts, HECToken(wc.Token), HECHost(wc.Host), HECSource(wc.Source), HECChannel(wc.Channel))
+		if len(wc.SourceType) != 0 {
+			opts = append(opts, HECSourceType(wc.SourceType))
+		}
+		if wc.BatchSize != 0 {
+			opts = append(opts, HECBatchSize(wc.BatchSize))
+		}
+		if len(wc.FlushInterval) != 0 {
+			d, err := configDuration("flush interval", wc.FlushInterval)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, HECFlushInterval(d))
+		}
+		w, err := OpenSplunkHEC(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	return nil, fmt.Errorf("tlog: unknown writer type %q", wc.Type)
+}
+
+func configDuration(name, s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("tlog: %s %q is illegal", name, s)
+	}
+	return d, nil
+}
+
+// watchConfig polls the config file and applies the changes of the writers
+// (to sw, nil if the writer isn't the one of the config) and of the levels
+func (tl *TLog) watchConfig(path string, interval time.Duration, c *Config, sw *swapWriter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	for {
+		select {
+		case <-tl.done:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil || (fi.ModTime().Equal(modTime) && fi.Size() == size) {
+			continue
+		}
+		modTime, size = fi.ModTime(), fi.Size()
+		if nc, err := tl.reloadConfig(path, c, sw); err != nil {
+			tl.Error().Str("config", path).Str("error", err.Error()).Msg("tlog: config reload failed")
+		} else {
+			c = nc
+		}
+	}
+}
+
+func (tl *TLog) reloadConfig(path string, c *Config, sw *swapWriter) (*Config, error) {
+	nc, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]int // none if the level is removed
+	if nc.Level != c.Level && len(nc.Level) != 0 {
+		if overrides, err = nc.levelOverrides(); err != nil {
+			return nil, err
+		}
+	}
+	if sw != nil && !reflect.DeepEqual(nc.Writers, c.Writers) {
+		w, err := nc.newWriter()
+		if err != nil {
+			return nil, err
+		}
+		sw.swap(w)
+	}
+	if nc.Level != c.Level {
+		tl.SetLevelOverrides(overrides)
+	}
+	if nc.Format != c.Format || nc.TimeFormat != c.TimeFormat || nc.TimeZone != c.TimeZone ||
+		nc.Reload != c.Reload || !reflect.DeepEqual(nc.Sampling, c.Sampling) ||
+		!reflect.DeepEqual(nc.Redact, c.Redact) {
+		tl.Warn().Str("config", path).Msg("tlog: config change needs a restart")
+	}
+	return nc, nil
+}
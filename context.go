@@ -0,0 +1,90 @@
+package tlog
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type ctxKey struct{}
+
+// set by SetDefault or created by Default
+var defaultTLog atomic.Pointer[TLog]
+
+// WithContext returns a copy of ctx carrying tl, see FromContext
+func WithContext(ctx context.Context, tl *TLog) context.Context {
+	return context.WithValue(ctx, ctxKey{}, tl)
+}
+
+// FromContext returns the TLog of ctx, the default one (see SetDefault) if
+// ctx has none
+func FromContext(ctx context.Context) *TLog {
+	if ctx != nil {
+		if tl, ok := ctx.Value(ctxKey{}).(*TLog); ok && tl != nil {
+			return tl
+		}
+	}
+	return Default()
+}
+
+// SetDefault sets the TLog returned by FromContext when the context has none
+func SetDefault(tl *TLog) {
+	if tl == nil {
+		panic("tlog:SetDefault param is illegal")
+	}
+	defaultTLog.Store(tl)
+}
+
+// Default returns the TLog set by SetDefault, New() if none is set
+func Default() *TLog {
+	if tl := defaultTLog.Load(); tl != nil {
+		return tl
+	}
+	defaultTLog.CompareAndSwap(nil, New())
+	return defaultTLog.Load()
+}
+
+// ContextExtractor adds fields from ctx to e, see ContextExtractors and
+// Encoder.Ctx
+type ContextExtractor func(ctx context.Context, e Encoder)
+
+// ExtractValue adds ctx.Value(key) as field k, if present
+func ExtractValue(key any, k string) ContextExtractor {
+	return func(ctx context.Context, e Encoder) {
+		switch v := ctx.Value(key).(type) {
+		case nil:
+		case string:
+			e.Str(k, v)
+		case int:
+			e.Int(k, v)
+		case int64:
+			e.Int64(k, v)
+		case uint64:
+			e.Uint64(k, v)
+		case fmt.Stringer:
+			e.Str(k, v.String())
+		default:
+			e.Any(k, v)
+		}
+	}
+}
+
+// ExtractDeadline adds the time left until the deadline of ctx as field k in
+// milliseconds, if ctx has a deadline
+func ExtractDeadline(k string) ContextExtractor {
+	return func(ctx context.Context, e Encoder) {
+		if d, ok := ctx.Deadline(); ok {
+			e.Int64(k, time.Until(d).Milliseconds())
+		}
+	}
+}
+
+func (e *encoder) appendCtx(self Encoder, ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	for _, f := range e.tl.ctxExtractors {
+		f(ctx, self)
+	}
+}
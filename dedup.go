@@ -0,0 +1,150 @@
+package tlog
+
+import (
+	"sync"
+	"time"
+)
+
+// deduper suppresses repeated lines, see Dedup
+type deduper struct {
+	window time.Duration
+	keys   []string
+
+	mtx     sync.Mutex
+	entries map[uint64]*dedupEntry
+}
+
+type dedupEntry struct {
+	until   int64 // unix nano, end of the window
+	repeats uint64
+	level   int
+	msg     string
+	fields  []byte // the encoded key fields, written on the summary line
+	logger  *TLog  // the named logger of the line
+}
+
+func newDeduper(window time.Duration, keys []string) *deduper {
+	return &deduper{
+		window:  window,
+		keys:    keys,
+		entries: make(map[uint64]*dedupEntry),
+	}
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+func fnvAdd(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+func fnvAddString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// suppress returns true if the same logger+level+msg(+keys) was written within
+// the window
+func (d *deduper) suppress(e *encoder) bool {
+	if e.internal || len(e.msg) == 0 { // Go only, nothing tells the lines apart
+		return false
+	}
+	h := uint64(fnvOffset64)
+	h = fnvAddString(h, e.tl.name)
+	h = fnvAdd(h, []byte{0, byte(e.level), byte(e.level >> 8)})
+	h = fnvAddString(h, e.msg)
+	for _, k := range d.keys {
+		for i := 0; i < e.nspans; i++ {
+			if sp := e.spans[i]; sp.key == k && sp.end > sp.start {
+				h = fnvAdd(h, e.buf[sp.start:sp.end])
+			}
+		}
+	}
+
+	now := e.now.UnixNano()
+	d.mtx.Lock()
+	ent, ok := d.entries[h]
+	if ok && now < ent.until {
+		ent.repeats++
+		d.mtx.Unlock()
+		return true
+	}
+	d.entries[h] = &dedupEntry{
+		until:  now + int64(d.window),
+		level:  e.level,
+		msg:    string([]byte(e.msg)), // e.msg may point into a reused buffer
+		fields: d.keyFields(e),
+		logger: e.tl,
+	}
+	d.mtx.Unlock()
+
+	if ok && ent.repeats > 0 {
+		// the window is closed but the sweeper didn't report it yet
+		writeRepeated(ent)
+	}
+	return false
+}
+
+// keyFields returns a copy of the key fields of the line, as encoded
+func (d *deduper) keyFields(e *encoder) []byte {
+	var fields []byte
+	for _, k := range d.keys {
+		for i := 0; i < e.nspans; i++ {
+			if sp := e.spans[i]; sp.key == k && sp.end > sp.start {
+				fields = append(fields, e.buf[sp.start:sp.end]...)
+			}
+		}
+	}
+	return fields
+}
+
+// sweep removes the closed windows and reports their repeats
+func (d *deduper) sweep(now int64) {
+	var closed []*dedupEntry
+	d.mtx.Lock()
+	for h, ent := range d.entries {
+		if now >= ent.until {
+			delete(d.entries, h)
+			if ent.repeats > 0 {
+				closed = append(closed, ent)
+			}
+		}
+	}
+	d.mtx.Unlock()
+	for _, ent := range closed {
+		writeRepeated(ent)
+	}
+}
+
+func (tl *TLog) dedupSweep() {
+	ticker := time.NewTicker(tl.dedup.window / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tl.done:
+			return
+		case now := <-ticker.C:
+			tl.dedup.sweep(now.UnixNano())
+		}
+	}
+}
+
+// writeRepeated writes the "repeated N times" summary of a suppressed line
+// with its logger and key fields, to tell the summaries apart
+func writeRepeated(ent *dedupEntry) {
+	e := ent.logger.encoder(ent.level, nil)
+	baseOf(e).internal = true
+	if len(ent.fields) != 0 {
+		e.(interface{ appendEncoded(b []byte) }).appendEncoded(ent.fields)
+	}
+	e.Uint64("repeated", ent.repeats).Msg(ent.msg)
+}
@@ -1,19 +1,33 @@
 package tlog
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"math"
+	"net"
+	"net/netip"
 	"strconv"
 	"time"
 	"unicode/utf8"
 )
 
 const (
-	HumanReadableTime   int = 1 // 2023-07-14 21:08:20
-	HumanReadableTimeMs int = 2 // 2023-07-14 21:08:20.212
-	UnixTimestamp       int = 3 // 1689340100
-	UnixTimestampMs     int = 4 // 1689340100123 // millisecond
+	HumanReadableTime   int = 1  // 2023-07-14 21:08:20
+	HumanReadableTimeMs int = 2  // 2023-07-14 21:08:20.212
+	UnixTimestamp       int = 3  // 1689340100
+	UnixTimestampMs     int = 4  // 1689340100123 // millisecond
+	UnixTimestampUs     int = 5  // 1689340100123456 // microsecond
+	UnixTimestampNs     int = 6  // 1689340100123456789 // nanosecond
+	RFC3339Time         int = 7  // 2023-07-14T21:08:20+08:00
+	RFC3339MsTime       int = 8  // 2023-07-14T21:08:20.212+08:00
+	RFC3339NanoTime     int = 9  // 2023-07-14T21:08:20.212345678+08:00
+	CustomTimeLayout    int = 10 // set by TimeLayout
 )
 
+const rfc3339Ms = "2006-01-02T15:04:05.000Z07:00"
+
 type AnyMarshalFuncT func(v any) ([]byte, error)
 
 type Encoder interface {
@@ -60,6 +74,31 @@ type Encoder interface {
 
 	Type(k string, v any) Encoder
 
+	// A number in the DurationUnit, float unless DurationInteger
+	Dur(k string, d time.Duration) Encoder
+	Durs(k string, vals []time.Duration) Encoder
+
+	// b as a string
+	Bytes(k string, b []byte) Encoder
+	// Lower case hex string
+	Hex(k string, b []byte) Encoder
+	// Standard base64 string
+	Base64(k string, b []byte) Encoder
+
+	// For a net.IP, netip.AddrFromSlice(ip) and Unmap() for an IPv4 in 16 bytes
+	IPAddr(k string, ip netip.Addr) Encoder
+	IPPrefix(k string, p netip.Prefix) Encoder
+	MACAddr(k string, mac net.HardwareAddr) Encoder
+
+	// v.String(), null if v is nil
+	Stringer(k string, v fmt.Stringer) Encoder
+
+	// t in the TimeFormat of the time field
+	Timestamp(k string, t time.Time) Encoder
+
+	// Add fields built with String, Int64, Err etc.
+	Fields(fields ...Field) Encoder
+
 	//
 	Any(k string, v any) Encoder
 
@@ -71,6 +110,23 @@ type Encoder interface {
 	// be valid JSON
 	RawJSON(k string, b []byte) Encoder
 
+	// Rate limit the line by k (e.g. the call site) instead of the
+	// RateLimitField value, see RateLimit
+	RateKey(k string) Encoder
+
+	// Add the fields of the ContextExtractors
+	Ctx(ctx context.Context) Encoder
+
+	// f adds fields to e, it's called by Msg/Go only if the line is going to
+	// be written (not disabled, sampled, vetoed or rate limited). The fields
+	// come after the msg.
+	Func(f func(e Encoder)) Encoder
+	// Like Func, Str(k, f())
+	LazyStr(k string, f func() string) Encoder
+
+	// The line won't be written, e.g. vetoed by a Hook
+	Discard() Encoder
+
 	// config
 	OmitEmpty(v bool) Encoder
 	AnyMarshalFunc(f AnyMarshalFuncT) Encoder
@@ -94,6 +150,30 @@ type encoder struct {
 	doneCallback   func(s string)
 	anyMarshalFunc AnyMarshalFuncT
     tl *TLog
+
+	scratch []byte // temporary space for rewriting a part of buf
+	fmtBuf  []byte // the message of Msgf
+
+	// tracked fields (TLog.trackKeys), offsets in buf
+	spans  [8]fieldSpan
+	nspans int
+
+	msg       string // set by Msg/Msgf
+	rateKey   string // set by RateKey
+	internal  bool   // a line written by tlog itself, e.g. a summary
+	discarded bool   // set by Discard
+
+	lazy []lazyField // added by Go, see keep
+
+	// the value of the last field, see beginField
+	fieldStart int      // -1 if none
+	maskFunc   MaskFunc // nil if the key isn't redacted
+	truncated  bool     // a value was cut to MaxFieldBytes/MaxLineBytes
+}
+
+type fieldSpan struct {
+	key        string
+	start, end int // the key and the value
 }
 
 const hex = "0123456789abcdef"
@@ -116,6 +196,152 @@ func init() {
 	}
 }
 
+func (e *encoder) reset(tl *TLog, lvl int, doneCallback func(s string)) {
+	e.tl = tl
+	e.level = lvl
+	e.omitEmpty = tl.omitEmpty
+	e.timeFormat = tl.timeFormat
+	e.writer = tl.writer
+	e.doneCallback = doneCallback
+	e.anyMarshalFunc = tl.anyMarshalFunc
+	e.nspans = 0
+	e.msg = ""
+	e.rateKey = ""
+	e.discarded = false
+	for i := range e.lazy {
+		e.lazy[i] = lazyField{}
+	}
+	e.lazy = e.lazy[:0]
+	e.fieldStart = -1
+	e.maskFunc = nil
+	e.truncated = false
+	e.internal = false
+}
+
+// trackKey must be called by appendKey before the key k is appended, the
+// field is recorded in e.spans if k is one of TLog.trackKeys
+func (e *encoder) trackKey(k string) {
+	if e.nspans > 0 && e.spans[e.nspans-1].end < 0 {
+		e.spans[e.nspans-1].end = len(e.buf)
+	}
+	if len(e.tl.trackKeys) == 0 || e.nspans == len(e.spans) {
+		return
+	}
+	for _, t := range e.tl.trackKeys {
+		if t == k {
+			e.spans[e.nspans] = fieldSpan{key: k, start: len(e.buf), end: -1}
+			e.nspans++
+			return
+		}
+	}
+}
+
+// endTracking must be called when the last field is complete
+func (e *encoder) endTracking() {
+	if e.nspans > 0 && e.spans[e.nspans-1].end < 0 {
+		e.spans[e.nspans-1].end = len(e.buf)
+	}
+}
+
+// beginField must be called by appendKey after the key k is appended, the
+// value is rewritten by closeField once it's complete if the key is redacted
+// or the value is too long.
+func (e *encoder) beginField(k string) {
+	if e.tl.redact == nil && e.tl.maxFieldBytes == 0 && e.tl.maxLineBytes == 0 {
+		return
+	}
+	e.fieldStart = len(e.buf)
+	e.maskFunc = nil
+	if e.tl.redact != nil {
+		e.maskFunc = e.tl.redact.keyMask(k)
+	}
+}
+
+// fieldBudget is the max length of the value of the last field
+func (e *encoder) fieldBudget() int {
+	n := math.MaxInt
+	if e.tl.maxFieldBytes > 0 {
+		n = e.tl.maxFieldBytes
+	}
+	if e.tl.maxLineBytes > 0 {
+		left := e.tl.maxLineBytes - e.fieldStart
+		if left < 0 {
+			left = 0
+		}
+		if left < n {
+			n = left
+		}
+	}
+	return n
+}
+
+// needRewrite must be called by closeField, if true the value must be
+// replaced by the string returned by rewriteField
+func (e *encoder) needRewrite() bool {
+	if e.fieldStart < 0 {
+		return false
+	}
+	if e.maskFunc == nil && len(e.buf)-e.fieldStart <= e.fieldBudget() {
+		e.fieldStart = -1
+		return false
+	}
+	return true
+}
+
+// rewriteField removes the value of the last field from buf and returns the
+// masked and/or truncated text of it, text is the value in a readable form
+func (e *encoder) rewriteField(text string) string {
+	n := e.fieldBudget()
+	e.buf = e.buf[:e.fieldStart]
+	e.fieldStart = -1
+	if e.maskFunc != nil {
+		text = e.maskFunc(text)
+		e.maskFunc = nil
+	}
+	if len(text) > n {
+		text = truncateText(text, n)
+		e.truncated = true
+		if cap(e.buf) > (1<<14) && len(e.buf) < (1<<13) {
+			// a huge value was cut, keep the buffer small enough for the pool
+			e.buf = append(make([]byte, 0, 1<<13), e.buf...)
+		}
+	}
+	return text
+}
+
+// write passes the finished line to the writer
+func (e *encoder) write(self Encoder) {
+	if e.discarded {
+		return
+	}
+	n, err := e.writer.Write(self, e.buf)
+	if err == nil && n < len(e.buf) {
+		err = fmt.Errorf("tlog: wrote %d of %d bytes: %w", n, len(e.buf), io.ErrShortWrite)
+	}
+	if err != nil {
+		e.tl.writeFailed(self, e.buf, err)
+	}
+}
+
+// baseOf returns the shared part of an encoder, nil for a nil encoder
+func baseOf(e Encoder) *encoder {
+	switch v := e.(type) {
+	case *encoderJson:
+		if v != nil {
+			return &v.encoder
+		}
+	case *encoderText:
+		if v != nil {
+			return &v.encoder
+		}
+	case *encoderCbor:
+		if v != nil {
+			return &v.encoder
+		}
+	}
+	return nil
+}
+
 func (e *encoder) Level() int {
 	return e.level
 }
@@ -133,8 +359,7 @@ func (e *encoder) appendHumanReadableTimeMs() {
 func (e *encoder) appendHumanReadableTime() {
 	year, month, day := e.now.Date()
 	hour, min, sec := e.now.Clock()
-	e.buf = append(e.buf, '2', '0')
-	e.appendTwoDigitsString(year % 100)
+	e.appendPaddingInt(year, 4)
 	e.buf = append(e.buf, '-')
 	e.appendTwoDigitsString(int(month))
 	e.buf = append(e.buf, '-')
@@ -146,6 +371,61 @@ func (e *encoder) appendHumanReadableTime() {
 	e.buf = append(e.buf, ':')
 	e.appendTwoDigitsString(sec)
 }
+
+// appendHeaderTimeValue appends e.now in e.timeFormat, string formats are
+// quoted if quote is true.
+func (e *encoder) appendHeaderTimeValue(quote bool) {
+	e.appendTimeValue(quote, e.tl.clock != nil)
+}
+
+// appendTimeValue is appendHeaderTimeValue, the formatted second is cached
+// for the coarse clock if cached is true
+func (e *encoder) appendTimeValue(quote, cached bool) {
+	switch e.timeFormat {
+	case UnixTimestamp:
+		e.buf = strconv.AppendInt(e.buf, e.now.Unix(), 10)
+		return
+	case UnixTimestampMs:
+		e.buf = strconv.AppendInt(e.buf, e.now.UnixMilli(), 10)
+		return
+	case UnixTimestampUs:
+		e.buf = strconv.AppendInt(e.buf, e.now.UnixMicro(), 10)
+		return
+	case UnixTimestampNs:
+		e.buf = strconv.AppendInt(e.buf, e.now.UnixNano(), 10)
+		return
+	}
+	if quote {
+		e.buf = append(e.buf, '"')
+	}
+	switch e.timeFormat {
+	case HumanReadableTime:
+		if cached {
+			e.appendCachedHumanReadableTime()
+		} else {
+			e.appendHumanReadableTime()
+		}
+	case HumanReadableTimeMs:
+		if cached {
+			e.appendCachedHumanReadableTime()
+			e.buf = append(e.buf, '.')
+			e.appendPaddingInt(e.now.Nanosecond()/1e6, 3)
+		} else {
+			e.appendHumanReadableTimeMs()
+		}
+	case RFC3339Time:
+		e.buf = e.now.AppendFormat(e.buf, time.RFC3339)
+	case RFC3339MsTime:
+		e.buf = e.now.AppendFormat(e.buf, rfc3339Ms)
+	case RFC3339NanoTime:
+		e.buf = e.now.AppendFormat(e.buf, time.RFC3339Nano)
+	case CustomTimeLayout:
+		e.buf = e.now.AppendFormat(e.buf, e.tl.timeLayout)
+	}
+	if quote {
+		e.buf = append(e.buf, '"')
+	}
+}
 func (e *encoder) appendPaddingInt(i int, wid int) {
 	// Assemble decimal in reverse order.
 	var b [8]byte
@@ -524,3 +804,50 @@ func (e *encoder) appendTime(t time.Time, format string) {
 func (e *encoder) appendRawJSON(k string, b []byte) {
 	e.buf = append(e.buf, b...)
 }
+func (e *encoder) appendDur(d time.Duration) {
+	if e.tl.durInteger {
+		e.buf = strconv.AppendInt(e.buf, int64(d/e.tl.durUnit), 10)
+	} else {
+		e.appendFloat(float64(d)/float64(e.tl.durUnit), 64)
+	}
+}
+func (e *encoder) appendDurs(vals []time.Duration) {
+	if vals == nil {
+		e.buf = append(e.buf, 'n', 'u', 'l', 'l')
+		return
+	}
+	if len(vals) == 0 {
+		e.buf = append(e.buf, '[', ']')
+		return
+	}
+	e.buf = append(e.buf, '[')
+	e.appendDur(vals[0])
+	if len(vals) > 1 {
+		for _, val := range vals[1:] {
+			e.buf = append(e.buf, ',')
+			e.appendDur(val)
+		}
+	}
+	e.buf = append(e.buf, ']')
+}
+func (e *encoder) appendBase64(b []byte) {
+	start := len(e.buf)
+	e.buf = append(e.buf, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+	base64.StdEncoding.Encode(e.buf[start:], b)
+}
+func (e *encoder) appendMAC(mac net.HardwareAddr) {
+	for i, c := range mac {
+		if i > 0 {
+			e.buf = append(e.buf, ':')
+		}
+		e.buf = append(e.buf, hex[c>>4], hex[c&0xf])
+	}
+}
+
+// appendTimestamp appends t like the header time, quoted if quote is true
+func (e *encoder) appendTimestamp(t time.Time, quote bool) {
+	now := e.now
+	e.now = t
+	e.appendTimeValue(quote, false)
+	e.now = now
+}
@@ -0,0 +1,836 @@
+package tlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// RFC 8949. Every log record is an indefinite-length map, records are written
+// back to back without separators, see CBORToJSON for reading them.
+
+const (
+	cborMajorUint   byte = 0 << 5
+	cborMajorNegInt byte = 1 << 5
+	cborMajorBytes  byte = 2 << 5
+	cborMajorText   byte = 3 << 5
+	cborMajorArray  byte = 4 << 5
+	cborMajorMap    byte = 5 << 5
+	cborMajorTag    byte = 6 << 5
+	cborMajorSimple byte = 7 << 5
+
+	cborFalse   byte = cborMajorSimple | 20
+	cborTrue    byte = cborMajorSimple | 21
+	cborNull    byte = cborMajorSimple | 22
+	cborFloat16 byte = cborMajorSimple | 25
+	cborFloat32 byte = cborMajorSimple | 26
+	cborFloat64 byte = cborMajorSimple | 27
+	cborBreak   byte = cborMajorSimple | 31
+
+	cborIndefiniteMap byte = cborMajorMap | 31
+
+	cborTagDateTimeString uint64 = 0
+	cborTagEpochDateTime  uint64 = 1
+	cborTagEmbeddedJSON   uint64 = 262
+)
+
+type encoderCbor struct {
+	encoder
+}
+
+func (e *encoderCbor) init() {
+	e.now = e.tl.timeNow()
+	e.buf = e.buf[:0]
+	e.buf = append(e.buf, cborIndefiniteMap)
+	e.appendHeaderTime()
+	e.appendLevelField()
+}
+func (e *encoderCbor) OmitEmpty(v bool) Encoder {
+	if e == nil {
+		return e
+	}
+	e.omitEmpty = v
+	return e
+}
+func (e *encoderCbor) AnyMarshalFunc(f AnyMarshalFuncT) Encoder {
+	if e == nil {
+		return e
+	}
+	e.anyMarshalFunc = f
+	return e
+}
+func (e *encoderCbor) appendHead(major byte, v uint64) {
+	switch {
+	case v < 24:
+		e.buf = append(e.buf, major|byte(v))
+	case v <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(v))
+	case v <= math.MaxUint16:
+		e.buf = append(e.buf, major|25)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+	case v <= math.MaxUint32:
+		e.buf = append(e.buf, major|26)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+	default:
+		e.buf = append(e.buf, major|27)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+	}
+}
+func (e *encoderCbor) appendInt(v int64) {
+	if v < 0 {
+		e.appendHead(cborMajorNegInt, uint64(-1-v))
+	} else {
+		e.appendHead(cborMajorUint, uint64(v))
+	}
+}
+func (e *encoderCbor) appendFloat(v float64, bitSize int) {
+	if bitSize == 32 {
+		e.buf = append(e.buf, cborFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v)))
+		return
+	}
+	e.buf = append(e.buf, cborFloat64)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+func (e *encoderCbor) appendBool(v bool) {
+	if v {
+		e.buf = append(e.buf, cborTrue)
+	} else {
+		e.buf = append(e.buf, cborFalse)
+	}
+}
+
+// Text strings must be valid UTF-8
+func (e *encoderCbor) appendString(s string) {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	e.appendHead(cborMajorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+func (e *encoderCbor) appendKey(k string) {
+	e.closeField()
+	e.trackKey(k)
+	e.appendString(k)
+	e.beginField(k)
+}
+
+// appendEncoded appends fields encoded by a line of the same logger, e.g. the
+// dedup keys
+func (e *encoderCbor) appendEncoded(b []byte) {
+	e.closeField()
+	e.fieldStart = -1
+	e.buf = append(e.buf, b...)
+}
+func (e *encoderCbor) fastAppendKey(k string) {
+	e.closeField()
+	e.trackKey(k)
+	e.appendHead(cborMajorText, uint64(len(k)))
+	e.buf = append(e.buf, k...)
+	e.beginField(k)
+}
+
+// A masked or truncated value is decoded to JSON text and written as a text
+// string
+func (e *encoderCbor) closeField() {
+	if !e.needRewrite() {
+		return
+	}
+	d := cborDecoder{r: bufio.NewReader(bytes.NewReader(e.buf[e.fieldStart:]))}
+	var text string
+	if d.decodeItem(0) == nil {
+		text = jsonText(d.enc.buf)
+	}
+	e.appendString(e.rewriteField(text))
+}
+func (e *encoderCbor) appendEmbeddedJSON(b []byte) {
+	e.appendHead(cborMajorTag, cborTagEmbeddedJSON)
+	e.appendHead(cborMajorBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// insertTextHead makes buf[start:] a text string, the bytes are moved behind
+// the head in place
+func (e *encoderCbor) insertTextHead(start int) {
+	if !utf8.Valid(e.buf[start:]) {
+		e.scratch = append(e.scratch[:0], e.buf[start:]...)
+		e.buf = e.buf[:start]
+		e.appendString(*(*string)(unsafe.Pointer(&e.scratch)))
+		return
+	}
+	end := len(e.buf)
+	e.appendHead(cborMajorText, uint64(end-start))
+	var head [9]byte
+	n := copy(head[:], e.buf[end:])
+	copy(e.buf[start+n:], e.buf[start:end])
+	copy(e.buf[start:], head[:n])
+}
+
+// Always tag 1, integer seconds or float seconds with sub-second precision.
+func (e *encoderCbor) appendHeaderTime() {
+	e.buf = append(e.buf, e.tl.timeKey...)
+	e.appendEpochTime()
+}
+func (e *encoderCbor) appendEpochTime() {
+	e.appendHead(cborMajorTag, cborTagEpochDateTime)
+	switch e.timeFormat {
+	case HumanReadableTime, UnixTimestamp, RFC3339Time:
+		e.appendInt(e.now.Unix())
+	case UnixTimestampUs, UnixTimestampNs, RFC3339NanoTime, CustomTimeLayout:
+		e.appendFloat(float64(e.now.UnixMicro())/1e6, 64)
+	default:
+		e.appendFloat(float64(e.now.UnixMilli())/1e3, 64)
+	}
+}
+
+func cborAppendInts[T int | int8 | int16 | int32 | int64](e *encoderCbor, vals []T) {
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return
+	}
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendInt(int64(v))
+	}
+}
+func cborAppendUints[T uint | uint8 | uint16 | uint32 | uint64](e *encoderCbor, vals []T) {
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return
+	}
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendHead(cborMajorUint, uint64(v))
+	}
+}
+func cborAppendFloats[T float32 | float64](e *encoderCbor, vals []T, bitSize int) {
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return
+	}
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendFloat(float64(v), bitSize)
+	}
+}
+
+func (e *encoderCbor) Fmt(k, format string, v ...any) Encoder {
+	if e == nil {
+		return e
+	}
+	e.closeField()
+	mark, nspans := len(e.buf), e.nspans
+	e.appendKey(k)
+	start := len(e.buf)
+	e.buf = fmt.Appendf(e.buf, format, v...)
+	if e.omitEmpty && len(e.buf) == start {
+		e.dropField(mark, nspans)
+		return e
+	}
+	e.redactFrom(k, start)
+	e.insertTextHead(start)
+	return e
+}
+func (e *encoderCbor) FastStr(k, v string) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(v) == 0 {
+		return e
+	}
+	e.fastAppendKey(k)
+	v = e.redactStr(k, v)
+	e.appendHead(cborMajorText, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+	return e
+}
+func (e *encoderCbor) Str(k, v string) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(v) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendString(e.redactStr(k, v))
+	return e
+}
+func (e *encoderCbor) Strs(k string, vals []string) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return e
+	}
+	vals = e.redactStrs(k, vals)
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendString(v)
+	}
+	return e
+}
+func (e *encoderCbor) Bool(k string, v bool) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendBool(v)
+	return e
+}
+func (e *encoderCbor) Bools(k string, vals []bool) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return e
+	}
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendBool(v)
+	}
+	return e
+}
+func (e *encoderCbor) Int(k string, v int) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendInt(int64(v))
+	return e
+}
+func (e *encoderCbor) Ints(k string, vals []int) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendInts(e, vals)
+	return e
+}
+func (e *encoderCbor) Int8(k string, v int8) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendInt(int64(v))
+	return e
+}
+func (e *encoderCbor) Ints8(k string, vals []int8) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendInts(e, vals)
+	return e
+}
+func (e *encoderCbor) Int16(k string, v int16) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendInt(int64(v))
+	return e
+}
+func (e *encoderCbor) Ints16(k string, vals []int16) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendInts(e, vals)
+	return e
+}
+func (e *encoderCbor) Int32(k string, v int32) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendInt(int64(v))
+	return e
+}
+func (e *encoderCbor) Ints32(k string, vals []int32) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendInts(e, vals)
+	return e
+}
+func (e *encoderCbor) Int64(k string, v int64) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendInt(v)
+	return e
+}
+func (e *encoderCbor) Ints64(k string, vals []int64) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendInts(e, vals)
+	return e
+}
+func (e *encoderCbor) Uint(k string, v uint) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorUint, uint64(v))
+	return e
+}
+func (e *encoderCbor) Uints(k string, vals []uint) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendUints(e, vals)
+	return e
+}
+func (e *encoderCbor) Uint8(k string, v uint8) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorUint, uint64(v))
+	return e
+}
+func (e *encoderCbor) Uints8(k string, vals []uint8) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendUints(e, vals)
+	return e
+}
+func (e *encoderCbor) Uint16(k string, v uint16) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorUint, uint64(v))
+	return e
+}
+func (e *encoderCbor) Uints16(k string, vals []uint16) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendUints(e, vals)
+	return e
+}
+func (e *encoderCbor) Uint32(k string, v uint32) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorUint, uint64(v))
+	return e
+}
+func (e *encoderCbor) Uints32(k string, vals []uint32) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendUints(e, vals)
+	return e
+}
+func (e *encoderCbor) Uint64(k string, v uint64) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorUint, v)
+	return e
+}
+func (e *encoderCbor) Uints64(k string, vals []uint64) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendUints(e, vals)
+	return e
+}
+func (e *encoderCbor) Float32(k string, v float32) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendFloat(float64(v), 32)
+	return e
+}
+func (e *encoderCbor) Floats32(k string, vals []float32) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendFloats(e, vals, 32)
+	return e
+}
+func (e *encoderCbor) Float64(k string, v float64) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendFloat(v, 64)
+	return e
+}
+func (e *encoderCbor) Floats64(k string, vals []float64) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	cborAppendFloats(e, vals, 64)
+	return e
+}
+func (e *encoderCbor) Type(k string, v any) Encoder {
+	if e == nil {
+		return e
+	}
+	if v == nil {
+		return e.Str(k, "<nil>")
+	}
+	return e.Str(k, reflect.TypeOf(v).String())
+}
+
+// The marshaled value is stored as embedded JSON (tag 262)
+func (e *encoderCbor) Any(k string, v any) Encoder {
+	if e == nil {
+		return e
+	}
+	marshaled, err := e.anyMarshalFunc(v)
+	if err != nil {
+		return e.Str(k, fmt.Sprintf("marshaling error: %s", err.Error()))
+	}
+	e.appendKey(k)
+	e.appendEmbeddedJSON(e.redactJSON(k, marshaled))
+	return e
+}
+
+// RFC 3339 layouts are stored as a standard date/time string (tag 0)
+func (e *encoderCbor) Time(k string, t time.Time, format string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	if format == time.RFC3339 || format == time.RFC3339Nano {
+		e.appendHead(cborMajorTag, cborTagDateTimeString)
+	}
+	start := len(e.buf)
+	e.appendTime(t, format)
+	n := len(e.buf) - start
+	// Move the formatted time behind the string head
+	e.scratch = append(e.scratch[:0], e.buf[start:]...)
+	e.buf = e.buf[:start]
+	e.appendHead(cborMajorText, uint64(n))
+	e.buf = append(e.buf, e.scratch...)
+	return e
+}
+
+// Stored as embedded JSON (tag 262)
+func (e *encoderCbor) RawJSON(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	if b == nil {
+		e.buf = append(e.buf, cborNull)
+		return e
+	}
+	e.appendEmbeddedJSON(e.redactJSON(k, b))
+	return e
+}
+func (e *encoderCbor) appendDur(d time.Duration) {
+	if e.tl.durInteger {
+		e.appendInt(int64(d / e.tl.durUnit))
+	} else {
+		e.appendFloat(float64(d)/float64(e.tl.durUnit), 64)
+	}
+}
+func (e *encoderCbor) Dur(k string, d time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendDur(d)
+	return e
+}
+func (e *encoderCbor) Durs(k string, vals []time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	if vals == nil {
+		e.buf = append(e.buf, cborNull)
+		return e
+	}
+	e.appendHead(cborMajorArray, uint64(len(vals)))
+	for _, v := range vals {
+		e.appendDur(v)
+	}
+	return e
+}
+
+// A text string, a byte string if b isn't valid UTF-8
+func (e *encoderCbor) Bytes(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	v := e.redactStr(k, *(*string)(unsafe.Pointer(&b)))
+	if !utf8.ValidString(v) {
+		e.appendHead(cborMajorBytes, uint64(len(v)))
+	} else {
+		e.appendHead(cborMajorText, uint64(len(v)))
+	}
+	e.buf = append(e.buf, v...)
+	return e
+}
+func (e *encoderCbor) Hex(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorText, uint64(len(b)*2))
+	e.appendHex(b)
+	return e
+}
+func (e *encoderCbor) Base64(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHead(cborMajorText, uint64(base64.StdEncoding.EncodedLen(len(b))))
+	e.appendBase64(b)
+	return e
+}
+func (e *encoderCbor) IPAddr(k string, ip netip.Addr) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	start := len(e.buf)
+	e.buf = ip.AppendTo(e.buf)
+	e.insertTextHead(start)
+	return e
+}
+func (e *encoderCbor) IPPrefix(k string, p netip.Prefix) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	start := len(e.buf)
+	e.buf = p.AppendTo(e.buf)
+	e.insertTextHead(start)
+	return e
+}
+func (e *encoderCbor) MACAddr(k string, mac net.HardwareAddr) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(mac) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	n := 0
+	if len(mac) > 0 {
+		n = len(mac)*3 - 1
+	}
+	e.appendHead(cborMajorText, uint64(n))
+	e.appendMAC(mac)
+	return e
+}
+func (e *encoderCbor) Stringer(k string, v fmt.Stringer) Encoder {
+	if e == nil {
+		return e
+	}
+	if v == nil {
+		e.appendKey(k)
+		e.buf = append(e.buf, cborNull)
+		return e
+	}
+	return e.Str(k, v.String())
+}
+
+// An epoch date/time (tag 1) like the time field
+func (e *encoderCbor) Timestamp(k string, t time.Time) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	now := e.now
+	e.now = t
+	e.appendEpochTime()
+	e.now = now
+	return e
+}
+func (e *encoderCbor) Fields(fields ...Field) Encoder {
+	if e == nil {
+		return e
+	}
+	appendFields(e, fields)
+	return e
+}
+func (e *encoderCbor) RateKey(k string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.rateKey = k
+	return e
+}
+func (e *encoderCbor) Ctx(ctx context.Context) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendCtx(e, ctx)
+	return e
+}
+func (e *encoderCbor) appendTrace(sc SpanContext) {
+	if e == nil {
+		return
+	}
+	e.fastAppendKey("trace_id")
+	e.appendHead(cborMajorText, uint64(len(sc.TraceID)*2))
+	e.appendHex(sc.TraceID[:])
+	e.fastAppendKey("span_id")
+	e.appendHead(cborMajorText, uint64(len(sc.SpanID)*2))
+	e.appendHex(sc.SpanID[:])
+	e.fastAppendKey("trace_flags")
+	e.appendHead(cborMajorText, 2)
+	e.buf = append(e.buf, hex[sc.TraceFlags>>4], hex[sc.TraceFlags&0xf])
+}
+func (e *encoderCbor) Func(f func(e Encoder)) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{fn: f})
+	return e
+}
+func (e *encoderCbor) LazyStr(k string, f func() string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{k: k, f: f})
+	return e
+}
+func (e *encoderCbor) Discard() Encoder {
+	if e == nil {
+		return e
+	}
+	e.discarded = true
+	return e
+}
+func (e *encoderCbor) Msg(s string) {
+	if e == nil {
+		return
+	}
+	e.msg = s
+	if len(s) > 0 {
+		e.Str(e.tl.msgKey, s)
+	}
+	e.Go()
+}
+func (e *encoderCbor) Msgf(format string, v ...any) {
+	if e == nil {
+		return
+	}
+	e.fmtBuf = fmt.Appendf(e.fmtBuf[:0], format, v...)
+	e.msg = *(*string)(unsafe.Pointer(&e.fmtBuf))
+	e.Str(e.tl.msgKey, e.msg)
+	e.Go()
+}
+func (e *encoderCbor) Go() {
+	if e == nil {
+		return
+	}
+	e.runHooks(e)
+	e.closeField()
+	if e.keep() && len(e.lazy) > 0 {
+		e.runLazy(e)
+		e.closeField()
+	}
+	if e.truncated {
+		e.Bool("truncated", true)
+		e.fieldStart = -1 // not limited
+	}
+	e.endTracking()
+	e.buf = append(e.buf, cborBreak)
+	e.write(e)
+
+	// See encoderJson.Go
+	if cap(e.buf) > (1<<14) || cap(e.fmtBuf) > (1<<14) { // 16KiB
+		return
+	}
+	e.tl.encoderCborPool.Put(e)
+}
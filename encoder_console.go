@@ -0,0 +1,141 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// FormatConsole shares encoderText, it's meant for development:
+//
+// 2023-07-14 21:08:20.212 INFO  user=tom err=refused hello
+//
+// The level column is aligned, keys are dimmed and `error`/`err` fields are
+// highlighted. With ConsoleMultiLine stack traces and RawJSON are rendered
+// below the line.
+
+const (
+	consoleColorAuto int = 0
+	consoleColorOn   int = 1
+	consoleColorOff  int = 2
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorDim   = "\x1b[2m"
+	colorGray  = "\x1b[90m"
+	colorRed   = "\x1b[31m"
+)
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (e *encoderText) initConsole() {
+	color := e.tl.consoleColor
+	if color {
+		e.buf = append(e.buf, colorGray...)
+	}
+	e.appendHeaderTime()
+	if color {
+		e.buf = append(e.buf, colorReset...)
+	}
+	e.appendLevelField()
+	e.hdrEnd = len(e.buf)
+}
+
+func (e *encoderText) appendConsoleKey(k string) {
+	e.closeConsoleValue()
+	e.trackKey(k)
+	e.buf = append(e.buf, ' ')
+	if !e.tl.consoleColor {
+		e.appendString(k)
+		e.buf = append(e.buf, '=')
+		return
+	}
+	if k == "error" || k == "err" {
+		e.buf = append(e.buf, colorRed...)
+		e.appendString(k)
+		e.buf = append(e.buf, '=')
+		e.colorOpen = true // until the end of value
+		return
+	}
+	e.buf = append(e.buf, colorDim...)
+	e.appendString(k)
+	e.buf = append(e.buf, '=')
+	e.buf = append(e.buf, colorReset...)
+}
+
+func (e *encoderText) closeConsoleValue() {
+	if e.colorOpen {
+		e.buf = append(e.buf, colorReset...)
+		e.colorOpen = false
+	}
+}
+
+// The message goes right after the level column
+func (e *encoderText) insertConsoleMsg(msg string) {
+	if len(msg) == 0 {
+		return
+	}
+	if e.tl.redact != nil {
+		msg = e.tl.redact.value(msg)
+	}
+	e.closeField()
+	e.closeConsoleValue()
+	e.endTracking()
+	e.scratch = append(e.scratch[:0], e.buf[e.hdrEnd:]...)
+	e.buf = e.buf[:e.hdrEnd]
+	e.buf = append(e.buf, ' ')
+	e.appendString(msg) // escaped like the values, a '\n' can't start a line
+	shift := len(e.buf) - e.hdrEnd
+	e.buf = append(e.buf, e.scratch...)
+	for i := 0; i < e.nspans; i++ {
+		e.spans[i].start += shift
+		e.spans[i].end += shift
+	}
+}
+
+func isStackKey(k string) bool {
+	return k == "stack" || k == "stacktrace"
+}
+
+func (e *encoderText) appendConsoleTailKey(k string) {
+	e.tail = append(e.tail, ' ', ' ')
+	if e.tl.consoleColor {
+		e.tail = append(e.tail, colorDim...)
+		e.tail = append(e.tail, k...)
+		e.tail = append(e.tail, ':')
+		e.tail = append(e.tail, colorReset...)
+	} else {
+		e.tail = append(e.tail, k...)
+		e.tail = append(e.tail, ':')
+	}
+	e.tail = append(e.tail, '\n')
+}
+
+func (e *encoderText) appendConsoleStack(k, v string) {
+	e.appendConsoleTailKey(k)
+	for _, line := range strings.Split(strings.TrimRight(v, "\n"), "\n") {
+		e.tail = append(e.tail, "    "...)
+		e.tail = append(e.tail, line...)
+		e.tail = append(e.tail, '\n')
+	}
+}
+
+func (e *encoderText) appendConsoleJSON(k string, b []byte) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "    ", "  "); err != nil {
+		e.appendConsoleStack(k, string(b))
+		return
+	}
+	e.appendConsoleTailKey(k)
+	e.tail = append(e.tail, "    "...)
+	e.tail = append(e.tail, out.Bytes()...)
+	e.tail = append(e.tail, '\n')
+}
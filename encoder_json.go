@@ -1,7 +1,10 @@
 package tlog
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"time"
@@ -13,122 +16,135 @@ type encoderJson struct {
 }
 
 func (e *encoderJson) init() {
-	e.now = time.Now()
+	e.now = e.tl.timeNow()
 	e.buf = e.buf[:0]
 	e.buf = append(e.buf, '{')
 	e.appendHeaderTime()
-	switch e.level {
-	case DebugLevel:
-		e.FastStr("level", "debug")
-	case InfoLevel:
-		e.FastStr("level", "info")
-	case WarnLevel:
-		e.FastStr("level", "warn")
-	case ErrorLevel:
-		e.FastStr("level", "error")
-	case FatalLevel:
-		e.FastStr("level", "fatal")
-	case PanicLevel:
-		e.FastStr("level", "panic")
-	}
+	e.appendLevelField()
 }
 func (e *encoderJson) OmitEmpty(v bool) Encoder {
+	if e == nil {
+		return e
+	}
 	e.omitEmpty = v
 	return e
 }
 func (e *encoderJson) AnyMarshalFunc(f AnyMarshalFuncT) Encoder {
+	if e == nil {
+		return e
+	}
 	e.anyMarshalFunc = f
 	return e
 }
 func (e *encoderJson) appendKey(k string) {
+	e.closeField()
+	e.trackKey(k)
 	if e.buf[len(e.buf)-1] != '{' {
 		e.buf = append(e.buf, ',')
 	}
 	e.buf = append(e.buf, '"')
 	e.appendString(k)
 	e.buf = append(e.buf, '"', ':')
+	e.beginField(k)
+}
+
+// appendEncoded appends fields encoded by a line of the same logger, e.g. the
+// dedup keys
+func (e *encoderJson) appendEncoded(b []byte) {
+	e.closeField()
+	e.fieldStart = -1
+	if b[0] == ',' && e.buf[len(e.buf)-1] == '{' {
+		b = b[1:]
+	} else if b[0] != ',' && e.buf[len(e.buf)-1] != '{' {
+		e.buf = append(e.buf, ',')
+	}
+	e.buf = append(e.buf, b...)
 }
 func (e *encoderJson) fastAppendKey(k string) {
+	e.closeField()
+	e.trackKey(k)
 	if e.buf[len(e.buf)-1] != '{' {
 		e.buf = append(e.buf, ',')
 	}
 	e.buf = append(e.buf, '"')
 	e.fastAppendString(k)
 	e.buf = append(e.buf, '"', ':')
+	e.beginField(k)
 }
-func (e *encoderJson) appendHeaderTime() {
-	e.appendKey("time")
-	if e.timeFormat == HumanReadableTime {
-		e.buf = append(e.buf, '"')
-		e.appendHumanReadableTime()
-		e.buf = append(e.buf, '"')
-	} else if e.timeFormat == HumanReadableTimeMs {
-		e.buf = append(e.buf, '"')
-		e.appendHumanReadableTimeMs()
-		e.buf = append(e.buf, '"')
-	} else if e.timeFormat == UnixTimestamp {
-		e.buf = strconv.AppendInt(e.buf, e.now.Unix(), 10)
-	} else if e.timeFormat == UnixTimestampMs {
-		e.buf = strconv.AppendInt(e.buf, e.now.UnixMilli(), 10)
+
+// A masked or truncated value is written as a string
+func (e *encoderJson) closeField() {
+	if !e.needRewrite() {
+		return
 	}
+	v := e.rewriteField(jsonText(e.buf[e.fieldStart:]))
+	e.buf = append(e.buf, '"')
+	e.appendString(v)
+	e.buf = append(e.buf, '"')
+}
+func (e *encoderJson) appendHeaderTime() {
+	e.buf = append(e.buf, e.tl.timeKey...)
+	e.appendHeaderTimeValue(true)
 }
 func (e *encoderJson) Fmt(k, format string, v ...any) Encoder {
 	if e == nil {
-		return nil
-	}
-	bf := make([]byte, 0, 256) // TODO
-	bf = fmt.Appendf(bf, format, v...)
-	if e.omitEmpty && len(bf) == 0 {
 		return e
 	}
+	e.closeField()
+	mark, nspans := len(e.buf), e.nspans
 	e.appendKey(k)
 	e.buf = append(e.buf, '"')
-	if len(bf) > 0 {
-		e.appendString(*(*string)(unsafe.Pointer(&bf)))
+	start := len(e.buf)
+	e.buf = fmt.Appendf(e.buf, format, v...)
+	if e.omitEmpty && len(e.buf) == start {
+		e.dropField(mark, nspans)
+		return e
 	}
+	e.redactFrom(k, start)
+	e.escapeFrom(start)
 	e.buf = append(e.buf, '"')
 	return e
 }
 func (e *encoderJson) FastStr(k, v string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(v) == 0 {
 		return e
 	}
 	e.fastAppendKey(k)
 	e.buf = append(e.buf, '"')
-	e.fastAppendString(v)
+	e.fastAppendString(e.redactStr(k, v))
 	e.buf = append(e.buf, '"')
 	return e
 }
 func (e *encoderJson) Str(k, v string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(v) == 0 {
 		return e
 	}
 	e.appendKey(k)
 	e.buf = append(e.buf, '"')
-	e.appendString(v)
+	e.appendString(e.redactStr(k, v))
 	e.buf = append(e.buf, '"')
 	return e
 }
 func (e *encoderJson) Strs(k string, vals []string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
 	}
 	e.appendKey(k)
-	e.appendStrings(vals)
+	e.appendStrings(e.redactStrs(k, vals))
 	return e
 }
 func (e *encoderJson) Bool(k string, v bool) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendBool(e.buf, v)
@@ -136,7 +152,7 @@ func (e *encoderJson) Bool(k string, v bool) Encoder {
 }
 func (e *encoderJson) Bools(k string, vals []bool) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -147,7 +163,7 @@ func (e *encoderJson) Bools(k string, vals []bool) Encoder {
 }
 func (e *encoderJson) Int(k string, v int) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -155,7 +171,7 @@ func (e *encoderJson) Int(k string, v int) Encoder {
 }
 func (e *encoderJson) Ints(k string, vals []int) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -166,7 +182,7 @@ func (e *encoderJson) Ints(k string, vals []int) Encoder {
 }
 func (e *encoderJson) Int8(k string, v int8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -174,7 +190,7 @@ func (e *encoderJson) Int8(k string, v int8) Encoder {
 }
 func (e *encoderJson) Ints8(k string, vals []int8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -185,7 +201,7 @@ func (e *encoderJson) Ints8(k string, vals []int8) Encoder {
 }
 func (e *encoderJson) Int16(k string, v int16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -193,7 +209,7 @@ func (e *encoderJson) Int16(k string, v int16) Encoder {
 }
 func (e *encoderJson) Ints16(k string, vals []int16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -204,7 +220,7 @@ func (e *encoderJson) Ints16(k string, vals []int16) Encoder {
 }
 func (e *encoderJson) Int32(k string, v int32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -212,7 +228,7 @@ func (e *encoderJson) Int32(k string, v int32) Encoder {
 }
 func (e *encoderJson) Ints32(k string, vals []int32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -223,7 +239,7 @@ func (e *encoderJson) Ints32(k string, vals []int32) Encoder {
 }
 func (e *encoderJson) Int64(k string, v int64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, v, 10)
@@ -231,7 +247,7 @@ func (e *encoderJson) Int64(k string, v int64) Encoder {
 }
 func (e *encoderJson) Ints64(k string, vals []int64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendInts64(vals)
@@ -239,7 +255,7 @@ func (e *encoderJson) Ints64(k string, vals []int64) Encoder {
 }
 func (e *encoderJson) Uint(k string, v uint) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -247,7 +263,7 @@ func (e *encoderJson) Uint(k string, v uint) Encoder {
 }
 func (e *encoderJson) Uints(k string, vals []uint) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -258,7 +274,7 @@ func (e *encoderJson) Uints(k string, vals []uint) Encoder {
 }
 func (e *encoderJson) Uint8(k string, v uint8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -266,7 +282,7 @@ func (e *encoderJson) Uint8(k string, v uint8) Encoder {
 }
 func (e *encoderJson) Uints8(k string, vals []uint8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -277,7 +293,7 @@ func (e *encoderJson) Uints8(k string, vals []uint8) Encoder {
 }
 func (e *encoderJson) Uint16(k string, v uint16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -285,7 +301,7 @@ func (e *encoderJson) Uint16(k string, v uint16) Encoder {
 }
 func (e *encoderJson) Uints16(k string, vals []uint16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -296,7 +312,7 @@ func (e *encoderJson) Uints16(k string, vals []uint16) Encoder {
 }
 func (e *encoderJson) Uint32(k string, v uint32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -304,7 +320,7 @@ func (e *encoderJson) Uint32(k string, v uint32) Encoder {
 }
 func (e *encoderJson) Uints32(k string, vals []uint32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -315,7 +331,7 @@ func (e *encoderJson) Uints32(k string, vals []uint32) Encoder {
 }
 func (e *encoderJson) Uint64(k string, v uint64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, v, 10)
@@ -323,7 +339,7 @@ func (e *encoderJson) Uint64(k string, v uint64) Encoder {
 }
 func (e *encoderJson) Uints64(k string, vals []uint64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -334,7 +350,7 @@ func (e *encoderJson) Uints64(k string, vals []uint64) Encoder {
 }
 func (e *encoderJson) Float32(k string, v float32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendFloat(float64(v), 32)
@@ -342,7 +358,7 @@ func (e *encoderJson) Float32(k string, v float32) Encoder {
 }
 func (e *encoderJson) Floats32(k string, vals []float32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -353,7 +369,7 @@ func (e *encoderJson) Floats32(k string, vals []float32) Encoder {
 }
 func (e *encoderJson) Float64(k string, v float64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendFloat(v, 64)
@@ -361,7 +377,7 @@ func (e *encoderJson) Float64(k string, v float64) Encoder {
 }
 func (e *encoderJson) Floats64(k string, vals []float64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -372,7 +388,7 @@ func (e *encoderJson) Floats64(k string, vals []float64) Encoder {
 }
 func (e *encoderJson) Type(k string, v any) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if v == nil {
 		return e.Str(k, "<nil>")
@@ -381,19 +397,19 @@ func (e *encoderJson) Type(k string, v any) Encoder {
 }
 func (e *encoderJson) Any(k string, v any) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	marshaled, err := e.anyMarshalFunc(v)
 	if err != nil {
 		return e.Str(k, fmt.Sprintf("marshaling error: %s", err.Error()))
 	}
 	e.appendKey(k)
-	e.buf = append(e.buf, marshaled...)
+	e.buf = append(e.buf, e.redactJSON(k, marshaled)...)
 	return e
 }
 func (e *encoderJson) Time(k string, t time.Time, format string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = append(e.buf, '"')
@@ -403,7 +419,7 @@ func (e *encoderJson) Time(k string, t time.Time, format string) Encoder {
 }
 func (e *encoderJson) RawJSON(k string, b []byte) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(b) == 0 {
 		return e
@@ -413,15 +429,183 @@ func (e *encoderJson) RawJSON(k string, b []byte) Encoder {
 		e.buf = append(e.buf, 'n', 'u', 'l', 'l')
 		return e
 	}
-	e.appendRawJSON(k, b)
+	e.appendRawJSON(k, e.redactJSON(k, b))
+	return e
+}
+func (e *encoderJson) Dur(k string, d time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendDur(d)
+	return e
+}
+func (e *encoderJson) Durs(k string, vals []time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendDurs(vals)
+	return e
+}
+func (e *encoderJson) Bytes(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.appendString(e.redactStr(k, *(*string)(unsafe.Pointer(&b))))
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) Hex(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.appendHex(b)
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) Base64(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.appendBase64(b)
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) IPAddr(k string, ip netip.Addr) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.buf = ip.AppendTo(e.buf)
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) IPPrefix(k string, p netip.Prefix) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.buf = p.AppendTo(e.buf)
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) MACAddr(k string, mac net.HardwareAddr) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(mac) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = append(e.buf, '"')
+	e.appendMAC(mac)
+	e.buf = append(e.buf, '"')
+	return e
+}
+func (e *encoderJson) Stringer(k string, v fmt.Stringer) Encoder {
+	if e == nil {
+		return e
+	}
+	if v == nil {
+		e.appendKey(k)
+		e.buf = append(e.buf, 'n', 'u', 'l', 'l')
+		return e
+	}
+	return e.Str(k, v.String())
+}
+func (e *encoderJson) Timestamp(k string, t time.Time) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendTimestamp(t, true)
+	return e
+}
+func (e *encoderJson) Fields(fields ...Field) Encoder {
+	if e == nil {
+		return e
+	}
+	appendFields(e, fields)
+	return e
+}
+func (e *encoderJson) RateKey(k string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.rateKey = k
+	return e
+}
+func (e *encoderJson) Ctx(ctx context.Context) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendCtx(e, ctx)
+	return e
+}
+func (e *encoderJson) appendTrace(sc SpanContext) {
+	if e == nil {
+		return
+	}
+	e.fastAppendKey("trace_id")
+	e.buf = append(e.buf, '"')
+	e.appendHex(sc.TraceID[:])
+	e.buf = append(e.buf, '"')
+	e.fastAppendKey("span_id")
+	e.buf = append(e.buf, '"')
+	e.appendHex(sc.SpanID[:])
+	e.buf = append(e.buf, '"')
+	e.fastAppendKey("trace_flags")
+	e.buf = append(e.buf, '"', hex[sc.TraceFlags>>4], hex[sc.TraceFlags&0xf], '"')
+}
+func (e *encoderJson) Func(f func(e Encoder)) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{fn: f})
+	return e
+}
+func (e *encoderJson) LazyStr(k string, f func() string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{k: k, f: f})
+	return e
+}
+func (e *encoderJson) Discard() Encoder {
+	if e == nil {
+		return e
+	}
+	e.discarded = true
 	return e
 }
 func (e *encoderJson) Msg(s string) {
 	if e == nil {
 		return
 	}
+	e.msg = s
 	if len(s) > 0 {
-		e.Str("msg", s)
+		e.Str(e.tl.msgKey, s)
 	}
     e.Go()
 }
@@ -429,16 +613,29 @@ func (e *encoderJson) Msgf(format string, v ...any) {
 	if e == nil {
 		return
 	}
-	e.Fmt("msg", format, v...)
+	e.fmtBuf = fmt.Appendf(e.fmtBuf[:0], format, v...)
+	e.msg = *(*string)(unsafe.Pointer(&e.fmtBuf))
+	e.Str(e.tl.msgKey, e.msg)
     e.Go()
 }
 func (e *encoderJson) Go() {
 	if e == nil {
 		return
 	}
+	e.runHooks(e)
+	e.closeField()
+	if e.keep() && len(e.lazy) > 0 {
+		e.runLazy(e)
+		e.closeField()
+	}
+	if e.truncated {
+		e.Bool("truncated", true)
+		e.fieldStart = -1 // not limited
+	}
+	e.endTracking()
 	e.buf = append(e.buf, '}')
 	e.buf = append(e.buf, '\n')
-	e.writer.Write(e, e.buf)
+	e.write(e)
 
     // Proper usage of a sync.Pool requires each entry to have approximately
 	// the same memory cost. To obtain this property when the stored type
@@ -446,7 +643,7 @@ func (e *encoderJson) Go() {
 	// to place back in the pool.
 	//
 	// See https://golang.org/issue/23199
-	if cap(e.buf) > (1 << 14) { // 16KiB
+	if cap(e.buf) > (1<<14) || cap(e.fmtBuf) > (1<<14) { // 16KiB
 		return
 	}
     e.tl.encoderJsonPool.Put(e)
@@ -0,0 +1,81 @@
+package tlog
+
+import (
+	"unicode/utf8"
+	"unsafe"
+)
+
+// FormatLogfmt shares encoderText, values are written raw and quoted/escaped
+// by closeLogfmtValue once the next key (or the end of line) is reached.
+//
+// time="2023-07-14 21:08:20.212" level=info user="tom cat" msg=hello
+
+func (e *encoderText) initLogfmt() {
+	e.buf = append(e.buf, e.tl.timeKey...)
+	e.valStart = len(e.buf)
+	e.appendHeaderTime()
+	e.closeLogfmtValue()
+	e.appendLevelField()
+}
+
+// Keys may not contain spaces, '=', '"', control characters or invalid UTF-8,
+// these are replaced by '_'.
+func (e *encoderText) appendLogfmtKey(k string) {
+	if len(k) == 0 {
+		e.buf = append(e.buf, '_')
+		return
+	}
+	for i := 0; i < len(k); {
+		b := k[i]
+		if b < utf8.RuneSelf {
+			if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+				b = '_'
+			}
+			e.buf = append(e.buf, b)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(k[i:])
+		if r == utf8.RuneError {
+			e.buf = append(e.buf, '_')
+		} else {
+			e.buf = append(e.buf, k[i:i+size]...)
+		}
+		i += size
+	}
+}
+
+func (e *encoderText) closeLogfmtValue() {
+	if e.valStart < 0 {
+		return
+	}
+	start := e.valStart
+	e.valStart = -1
+	if !logfmtNeedsQuote(e.buf[start:]) {
+		return
+	}
+	e.scratch = append(e.scratch[:0], e.buf[start:]...)
+	e.buf = e.buf[:start]
+	e.buf = append(e.buf, '"')
+	e.appendString(*(*string)(unsafe.Pointer(&e.scratch)))
+	e.buf = append(e.buf, '"')
+}
+
+func logfmtNeedsQuote(v []byte) bool {
+	for i := 0; i < len(v); {
+		b := v[i]
+		if b < utf8.RuneSelf {
+			if b <= ' ' || b == '=' || b == '"' || b == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(v[i:])
+		if r == utf8.RuneError && size == 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
@@ -1,7 +1,10 @@
 package tlog
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"time"
@@ -10,109 +13,193 @@ import (
 
 type encoderText struct {
 	encoder
+
+	logfmt   bool // FormatLogfmt
+	valStart int  // for logfmt, start of the value being written, -1 if none
+
+	console   bool   // FormatConsole
+	colorOpen bool   // for console, a color span is open until the end of value
+	hdrEnd    int    // for console, end of the time/level header
+	tail      []byte // for console, lines rendered below the log line
 }
 
 func (e *encoderText) init() {
-	e.now = time.Now()
+	e.now = e.tl.timeNow()
 	e.buf = e.buf[:0]
-	e.appendHeaderTime()
-	switch e.level {
-	case DebugLevel:
-		e.buf = append(e.buf, " debug"...)
-	case InfoLevel:
-		e.buf = append(e.buf, " info"...)
-	case WarnLevel:
-		e.buf = append(e.buf, " warn"...)
-	case ErrorLevel:
-		e.buf = append(e.buf, " error"...)
-	case FatalLevel:
-		e.buf = append(e.buf, " fatal"...)
-	case PanicLevel:
-		e.buf = append(e.buf, " panic"...)
+	e.valStart = -1
+	if e.logfmt {
+		e.initLogfmt()
+		return
 	}
+	if e.console {
+		e.colorOpen = false
+		e.tail = e.tail[:0]
+		e.initConsole()
+		return
+	}
+	e.appendHeaderTime()
+	e.appendLevelField()
 }
 func (e *encoderText) OmitEmpty(v bool) Encoder {
+	if e == nil {
+		return e
+	}
 	e.omitEmpty = v
 	return e
 }
 func (e *encoderText) AnyMarshalFunc(f AnyMarshalFuncT) Encoder {
+	if e == nil {
+		return e
+	}
 	e.anyMarshalFunc = f
 	return e
 }
 func (e *encoderText) appendKey(k string) {
+	e.closeField()
+	if e.logfmt {
+		e.closeLogfmtValue()
+		e.trackKey(k)
+		e.buf = append(e.buf, ' ')
+		e.appendLogfmtKey(k)
+		e.buf = append(e.buf, '=')
+		e.valStart = len(e.buf)
+		e.beginField(k)
+		return
+	}
+	if e.console {
+		e.appendConsoleKey(k)
+		e.beginField(k)
+		return
+	}
+	e.trackKey(k)
 	e.buf = append(e.buf, ' ')
 	e.appendString(k)
 	e.buf = append(e.buf, '=')
+	e.beginField(k)
+}
+
+// appendEncoded appends fields encoded by a line of the same logger, e.g. the
+// dedup keys
+func (e *encoderText) appendEncoded(b []byte) {
+	e.closeField()
+	if e.logfmt {
+		e.closeLogfmtValue()
+	} else if e.console {
+		e.closeConsoleValue()
+	}
+	e.fieldStart = -1
+	e.buf = append(e.buf, b...)
 }
 func (e *encoderText) fastAppendKey(k string) {
+	e.closeField()
+	if e.logfmt {
+		e.closeLogfmtValue()
+	} else if e.console {
+		e.appendConsoleKey(k)
+		e.beginField(k)
+		return
+	}
+	e.trackKey(k)
 	e.buf = append(e.buf, ' ')
 	e.fastAppendString(k)
 	e.buf = append(e.buf, '=')
+	e.valStart = len(e.buf)
+	e.beginField(k)
 }
-func (e *encoderText) appendHeaderTime() {
-	if e.timeFormat == HumanReadableTime {
-		e.appendHumanReadableTime()
-	} else if e.timeFormat == HumanReadableTimeMs {
-		e.appendHumanReadableTimeMs()
-	} else if e.timeFormat == UnixTimestamp {
-		e.buf = strconv.AppendInt(e.buf, e.now.Unix(), 10)
-	} else if e.timeFormat == UnixTimestamp {
-		e.buf = strconv.AppendInt(e.buf, e.now.Unix(), 10)
-	} else if e.timeFormat == UnixTimestampMs {
-		e.buf = strconv.AppendInt(e.buf, e.now.UnixMilli(), 10)
+
+// Must be called before the logfmt/console value is closed
+func (e *encoderText) closeField() {
+	if !e.needRewrite() {
+		return
+	}
+	v := string(e.buf[e.fieldStart:])
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' && !e.logfmt {
+		v = v[1 : len(v)-1] // Time
 	}
+	v = e.rewriteField(v)
+	if e.logfmt {
+		e.buf = append(e.buf, v...) // quoted by closeLogfmtValue
+	} else {
+		e.appendString(v)
+	}
+}
+func (e *encoderText) appendHeaderTime() {
+	e.appendHeaderTimeValue(false)
 }
 func (e *encoderText) Fmt(k, format string, v ...any) Encoder {
 	if e == nil {
-		return nil
-	}
-	bf := make([]byte, 0, 256) // TODO
-	bf = fmt.Appendf(bf, format, v...)
-	if e.omitEmpty && len(bf) == 0 {
 		return e
 	}
+	e.closeField()
+	if e.logfmt {
+		e.closeLogfmtValue()
+	} else if e.console {
+		e.closeConsoleValue()
+	}
+	mark, nspans := len(e.buf), e.nspans
 	e.appendKey(k)
-	if len(bf) > 0 {
-		e.appendString(*(*string)(unsafe.Pointer(&bf)))
+	start := len(e.buf)
+	e.buf = fmt.Appendf(e.buf, format, v...)
+	if e.omitEmpty && len(e.buf) == start {
+		e.dropField(mark, nspans)
+		e.valStart = -1
+		e.colorOpen = false
+		return e
+	}
+	e.redactFrom(k, start)
+	if !e.logfmt { // logfmt values are quoted by closeLogfmtValue
+		e.escapeFrom(start)
 	}
 	return e
 }
 func (e *encoderText) FastStr(k, v string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(v) == 0 {
 		return e
 	}
 	e.fastAppendKey(k)
-	e.fastAppendString(v)
+	e.fastAppendString(e.redactStr(k, v))
 	return e
 }
 func (e *encoderText) Str(k, v string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(v) == 0 {
 		return e
 	}
+	if e.console && e.tl.consoleMultiLine && isStackKey(k) {
+		if e.tl.redact != nil {
+			v = e.tl.redact.str(k, v)
+		}
+		e.appendConsoleStack(k, v)
+		return e
+	}
 	e.appendKey(k)
-	e.appendString(v)
+	v = e.redactStr(k, v)
+	if e.logfmt {
+		e.fastAppendString(v)
+	} else {
+		e.appendString(v)
+	}
 	return e
 }
 func (e *encoderText) Strs(k string, vals []string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
 	}
 	e.appendKey(k)
-	e.appendStrings(vals)
+	e.appendStrings(e.redactStrs(k, vals))
 	return e
 }
 func (e *encoderText) Bool(k string, v bool) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendBool(e.buf, v)
@@ -120,7 +207,7 @@ func (e *encoderText) Bool(k string, v bool) Encoder {
 }
 func (e *encoderText) Bools(k string, vals []bool) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -131,7 +218,7 @@ func (e *encoderText) Bools(k string, vals []bool) Encoder {
 }
 func (e *encoderText) Int(k string, v int) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -139,7 +226,7 @@ func (e *encoderText) Int(k string, v int) Encoder {
 }
 func (e *encoderText) Ints(k string, vals []int) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -150,7 +237,7 @@ func (e *encoderText) Ints(k string, vals []int) Encoder {
 }
 func (e *encoderText) Int8(k string, v int8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -158,7 +245,7 @@ func (e *encoderText) Int8(k string, v int8) Encoder {
 }
 func (e *encoderText) Ints8(k string, vals []int8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -169,7 +256,7 @@ func (e *encoderText) Ints8(k string, vals []int8) Encoder {
 }
 func (e *encoderText) Int16(k string, v int16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -177,7 +264,7 @@ func (e *encoderText) Int16(k string, v int16) Encoder {
 }
 func (e *encoderText) Ints16(k string, vals []int16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -188,7 +275,7 @@ func (e *encoderText) Ints16(k string, vals []int16) Encoder {
 }
 func (e *encoderText) Int32(k string, v int32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, int64(v), 10)
@@ -196,7 +283,7 @@ func (e *encoderText) Int32(k string, v int32) Encoder {
 }
 func (e *encoderText) Ints32(k string, vals []int32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -207,7 +294,7 @@ func (e *encoderText) Ints32(k string, vals []int32) Encoder {
 }
 func (e *encoderText) Int64(k string, v int64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendInt(e.buf, v, 10)
@@ -215,7 +302,7 @@ func (e *encoderText) Int64(k string, v int64) Encoder {
 }
 func (e *encoderText) Ints64(k string, vals []int64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendInts64(vals)
@@ -223,7 +310,7 @@ func (e *encoderText) Ints64(k string, vals []int64) Encoder {
 }
 func (e *encoderText) Uint(k string, v uint) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -231,7 +318,7 @@ func (e *encoderText) Uint(k string, v uint) Encoder {
 }
 func (e *encoderText) Uints(k string, vals []uint) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -242,7 +329,7 @@ func (e *encoderText) Uints(k string, vals []uint) Encoder {
 }
 func (e *encoderText) Uint8(k string, v uint8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -250,7 +337,7 @@ func (e *encoderText) Uint8(k string, v uint8) Encoder {
 }
 func (e *encoderText) Uints8(k string, vals []uint8) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -261,7 +348,7 @@ func (e *encoderText) Uints8(k string, vals []uint8) Encoder {
 }
 func (e *encoderText) Uint16(k string, v uint16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -269,7 +356,7 @@ func (e *encoderText) Uint16(k string, v uint16) Encoder {
 }
 func (e *encoderText) Uints16(k string, vals []uint16) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -280,7 +367,7 @@ func (e *encoderText) Uints16(k string, vals []uint16) Encoder {
 }
 func (e *encoderText) Uint32(k string, v uint32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
@@ -288,7 +375,7 @@ func (e *encoderText) Uint32(k string, v uint32) Encoder {
 }
 func (e *encoderText) Uints32(k string, vals []uint32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -299,7 +386,7 @@ func (e *encoderText) Uints32(k string, vals []uint32) Encoder {
 }
 func (e *encoderText) Uint64(k string, v uint64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.buf = strconv.AppendUint(e.buf, v, 10)
@@ -307,7 +394,7 @@ func (e *encoderText) Uint64(k string, v uint64) Encoder {
 }
 func (e *encoderText) Uints64(k string, vals []uint64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -318,7 +405,7 @@ func (e *encoderText) Uints64(k string, vals []uint64) Encoder {
 }
 func (e *encoderText) Float32(k string, v float32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendFloat(float64(v), 32)
@@ -326,7 +413,7 @@ func (e *encoderText) Float32(k string, v float32) Encoder {
 }
 func (e *encoderText) Floats32(k string, vals []float32) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -337,7 +424,7 @@ func (e *encoderText) Floats32(k string, vals []float32) Encoder {
 }
 func (e *encoderText) Float64(k string, v float64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
 	e.appendFloat(v, 64)
@@ -345,7 +432,7 @@ func (e *encoderText) Float64(k string, v float64) Encoder {
 }
 func (e *encoderText) Floats64(k string, vals []float64) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(vals) == 0 {
 		return e
@@ -356,7 +443,7 @@ func (e *encoderText) Floats64(k string, vals []float64) Encoder {
 }
 func (e *encoderText) Type(k string, v any) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if v == nil {
 		return e.Str(k, "<nil>")
@@ -365,21 +452,25 @@ func (e *encoderText) Type(k string, v any) Encoder {
 }
 func (e *encoderText) Any(k string, v any) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	marshaled, err := e.anyMarshalFunc(v)
 	if err != nil {
 		return e.Str(k, fmt.Sprintf("marshaling error: %s", err.Error()))
 	}
 	e.appendKey(k)
-	e.buf = append(e.buf, marshaled...)
+	e.buf = append(e.buf, e.redactJSON(k, marshaled)...)
 	return e
 }
 func (e *encoderText) Time(k string, t time.Time, format string) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	e.appendKey(k)
+	if e.logfmt {
+		e.appendTime(t, format)
+		return e
+	}
 	e.buf = append(e.buf, '"')
 	e.appendTime(t, format)
 	e.buf = append(e.buf, '"')
@@ -387,50 +478,251 @@ func (e *encoderText) Time(k string, t time.Time, format string) Encoder {
 }
 func (e *encoderText) RawJSON(k string, b []byte) Encoder {
 	if e == nil {
-		return nil
+		return e
 	}
 	if e.omitEmpty && len(b) == 0 {
 		return e
 	}
+	if e.console && e.tl.consoleMultiLine && b != nil {
+		if e.tl.redact != nil {
+			b = e.tl.redact.json(k, b)
+		}
+		e.appendConsoleJSON(k, b)
+		return e
+	}
 	e.appendKey(k)
 	if b == nil {
 		e.buf = append(e.buf, 'n', 'u', 'l', 'l')
 		return e
 	}
-	e.appendRawJSON(k, b)
+	e.appendRawJSON(k, e.redactJSON(k, b))
+	return e
+}
+func (e *encoderText) Dur(k string, d time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendDur(d)
+	return e
+}
+func (e *encoderText) Durs(k string, vals []time.Duration) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(vals) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendDurs(vals)
+	return e
+}
+func (e *encoderText) Bytes(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	v := e.redactStr(k, *(*string)(unsafe.Pointer(&b)))
+	if e.logfmt {
+		e.fastAppendString(v)
+	} else {
+		e.appendString(v)
+	}
+	return e
+}
+func (e *encoderText) Hex(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendHex(b)
+	return e
+}
+func (e *encoderText) Base64(k string, b []byte) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(b) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendBase64(b)
+	return e
+}
+func (e *encoderText) IPAddr(k string, ip netip.Addr) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = ip.AppendTo(e.buf)
+	return e
+}
+func (e *encoderText) IPPrefix(k string, p netip.Prefix) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.buf = p.AppendTo(e.buf)
+	return e
+}
+func (e *encoderText) MACAddr(k string, mac net.HardwareAddr) Encoder {
+	if e == nil {
+		return e
+	}
+	if e.omitEmpty && len(mac) == 0 {
+		return e
+	}
+	e.appendKey(k)
+	e.appendMAC(mac)
+	return e
+}
+func (e *encoderText) Stringer(k string, v fmt.Stringer) Encoder {
+	if e == nil {
+		return e
+	}
+	if v == nil {
+		e.appendKey(k)
+		e.buf = append(e.buf, 'n', 'u', 'l', 'l')
+		return e
+	}
+	return e.Str(k, v.String())
+}
+func (e *encoderText) Timestamp(k string, t time.Time) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendKey(k)
+	e.appendTimestamp(t, !e.logfmt)
+	return e
+}
+func (e *encoderText) Fields(fields ...Field) Encoder {
+	if e == nil {
+		return e
+	}
+	appendFields(e, fields)
+	return e
+}
+func (e *encoderText) RateKey(k string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.rateKey = k
+	return e
+}
+func (e *encoderText) Ctx(ctx context.Context) Encoder {
+	if e == nil {
+		return e
+	}
+	e.appendCtx(e, ctx)
+	return e
+}
+func (e *encoderText) appendTrace(sc SpanContext) {
+	if e == nil {
+		return
+	}
+	e.fastAppendKey("trace_id")
+	e.appendHex(sc.TraceID[:])
+	e.fastAppendKey("span_id")
+	e.appendHex(sc.SpanID[:])
+	e.fastAppendKey("trace_flags")
+	e.buf = append(e.buf, hex[sc.TraceFlags>>4], hex[sc.TraceFlags&0xf])
+}
+func (e *encoderText) Func(f func(e Encoder)) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{fn: f})
+	return e
+}
+func (e *encoderText) LazyStr(k string, f func() string) Encoder {
+	if e == nil {
+		return e
+	}
+	e.lazy = append(e.lazy, lazyField{k: k, f: f})
+	return e
+}
+func (e *encoderText) Discard() Encoder {
+	if e == nil {
+		return e
+	}
+	e.discarded = true
 	return e
 }
 func (e *encoderText) Msg(s string) {
 	if e == nil {
 		return
 	}
+	if e.console {
+		e.msg = s
+		e.insertConsoleMsg(s)
+		e.Go()
+		return
+	}
+	e.msg = s
 	if len(s) > 0 {
-		e.Str("msg", s)
+		e.Str(e.tl.msgKey, s)
 	}
-    e.Go()
+	e.Go()
 }
 func (e *encoderText) Msgf(format string, v ...any) {
 	if e == nil {
 		return
 	}
-	e.Fmt("msg", format, v...)
+	e.fmtBuf = fmt.Appendf(e.fmtBuf[:0], format, v...)
+	e.msg = *(*string)(unsafe.Pointer(&e.fmtBuf))
+	if e.console {
+		e.insertConsoleMsg(e.msg)
+		e.Go()
+		return
+	}
+	e.Str(e.tl.msgKey, e.msg)
 	e.Go()
 }
 func (e *encoderText) Go() {
 	if e == nil {
 		return
 	}
+	e.runHooks(e)
+	e.closeField()
+	if e.logfmt {
+		e.closeLogfmtValue()
+	} else if e.console {
+		e.closeConsoleValue()
+	}
+	if e.keep() && len(e.lazy) > 0 {
+		e.runLazy(e)
+		e.closeField()
+	}
+	if e.truncated {
+		e.Bool("truncated", true)
+		e.fieldStart = -1 // not limited
+	}
+	if e.logfmt {
+		e.closeLogfmtValue()
+	} else if e.console {
+		e.closeConsoleValue()
+	}
+	e.endTracking()
 	e.buf = append(e.buf, '\n')
-	e.writer.Write(e, e.buf)
+	if len(e.tail) > 0 {
+		e.buf = append(e.buf, e.tail...)
+	}
+	e.write(e)
 
-    // Proper usage of a sync.Pool requires each entry to have approximately
+	// Proper usage of a sync.Pool requires each entry to have approximately
 	// the same memory cost. To obtain this property when the stored type
 	// contains a variably-sized buffer, we add a hard limit on the maximum buffer
 	// to place back in the pool.
 	//
 	// See https://golang.org/issue/23199
-	if cap(e.buf) > (1 << 14) { // 16KiB
+	if cap(e.buf) > (1<<14) || cap(e.fmtBuf) > (1<<14) || cap(e.tail) > (1<<14) { // 16KiB
 		return
 	}
-    e.tl.encoderTextPool.Put(e)
+	e.tl.encoderTextPool.Put(e)
 }
@@ -0,0 +1,75 @@
+package tlog
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrorHandlerFunc receives the failures of the writer, err is the last one
+// and failed the number of failed writes since the previous call. It's called
+// in the logging goroutine, or in a timer goroutine for the failures left at
+// the end of an interval, and must not log with the TLog.
+type ErrorHandlerFunc func(err error, failed uint64)
+
+// errorReporter calls the ErrorHandlerFunc at most once per interval, the
+// failures within the interval are reported when it ends
+type errorReporter struct {
+	f        ErrorHandlerFunc
+	interval time.Duration
+
+	mtx     sync.Mutex
+	next    time.Time // of the next call allowed
+	failed  uint64    // since the last call
+	lastErr error
+	timer   *time.Timer // flushes the pending failures, nil if none
+}
+
+func (r *errorReporter) report(err error) {
+	r.mtx.Lock()
+	r.failed++
+	r.lastErr = err
+	now := time.Now()
+	if now.Before(r.next) {
+		if r.timer == nil {
+			r.timer = time.AfterFunc(r.next.Sub(now), r.flush)
+		}
+		r.mtx.Unlock()
+		return
+	}
+	r.next = now.Add(r.interval)
+	failed := r.failed
+	r.failed, r.lastErr = 0, nil
+	r.mtx.Unlock()
+	r.f(err, failed)
+}
+
+// flush reports the pending failures, see TLog.Close
+func (r *errorReporter) flush() {
+	r.mtx.Lock()
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.failed == 0 {
+		r.mtx.Unlock()
+		return
+	}
+	r.next = time.Now().Add(r.interval)
+	failed, err := r.failed, r.lastErr
+	r.failed, r.lastErr = 0, nil
+	r.mtx.Unlock()
+	r.f(err, failed)
+}
+
+// writeFailed handles a line the writer failed to write
+func (tl *TLog) writeFailed(e Encoder, p []byte, err error) {
+	tl.stats.writeFailed.Add(1)
+	if tl.fallback == nil {
+		tl.stats.lost.Add(1)
+	} else if n, ferr := tl.fallback.Write(e, p); ferr != nil || n < len(p) {
+		tl.stats.lost.Add(1)
+	}
+	if tl.errReporter != nil {
+		tl.errReporter.report(err)
+	}
+}
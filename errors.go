@@ -0,0 +1,33 @@
+package tlog
+
+import "errors"
+
+// ErrIllegalParam matches (with errors.Is) the errors of the options given
+// an illegal param
+var ErrIllegalParam = errors.New("tlog: illegal param")
+
+// OptionError is returned by NewE and the Open* writer constructors when an
+// Option is given an illegal param, New and the NewWriteTo* constructors
+// panic with it. The I/O failures are *os.PathError.
+type OptionError struct {
+	Option string // e.g. "Format"
+	Err    error  // the cause, may be nil
+}
+
+func (e *OptionError) Error() string {
+	s := "tlog:" + e.Option + " param is illegal"
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+func (e *OptionError) Is(target error) bool {
+	return target == ErrIllegalParam
+}
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+func optionError(option string) error {
+	return &OptionError{Option: option}
+}
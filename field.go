@@ -0,0 +1,138 @@
+package tlog
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type fieldKind uint8
+
+const (
+	stringField fieldKind = iota + 1
+	stringsField
+	boolField
+	intField
+	int64Field
+	uint64Field
+	float64Field
+	durField
+	timeField
+	errField
+	bytesField
+	stringerField
+	anyField
+)
+
+// Field is a key/value built apart from an Encoder, so that helpers and
+// libraries can pass fields around, see Encoder.Fields and TLog.Log.
+type Field struct {
+	Key string
+
+	kind fieldKind
+	num  int64
+	str  string
+	val  any
+}
+
+func String(k, v string) Field {
+	return Field{Key: k, kind: stringField, str: v}
+}
+func Strings(k string, v []string) Field {
+	return Field{Key: k, kind: stringsField, val: v}
+}
+func Bool(k string, v bool) Field {
+	f := Field{Key: k, kind: boolField}
+	if v {
+		f.num = 1
+	}
+	return f
+}
+func Int(k string, v int) Field {
+	return Field{Key: k, kind: intField, num: int64(v)}
+}
+func Int64(k string, v int64) Field {
+	return Field{Key: k, kind: int64Field, num: v}
+}
+func Uint64(k string, v uint64) Field {
+	return Field{Key: k, kind: uint64Field, num: int64(v)}
+}
+func Float64(k string, v float64) Field {
+	return Field{Key: k, kind: float64Field, num: int64(math.Float64bits(v))}
+}
+func Dur(k string, v time.Duration) Field {
+	return Field{Key: k, kind: durField, num: int64(v)}
+}
+
+// Time is written like Encoder.Timestamp
+func Time(k string, v time.Time) Field {
+	return Field{Key: k, kind: timeField, num: v.UnixNano(), val: v.Location()}
+}
+
+// Err is the `error` field, nothing is written if err is nil
+func Err(err error) Field {
+	return NamedErr("error", err)
+}
+func NamedErr(k string, err error) Field {
+	return Field{Key: k, kind: errField, val: err}
+}
+func Bytes(k string, v []byte) Field {
+	return Field{Key: k, kind: bytesField, val: v}
+}
+func Stringer(k string, v fmt.Stringer) Field {
+	return Field{Key: k, kind: stringerField, val: v}
+}
+
+// Any is written with the AnyMarshalFunc
+func Any(k string, v any) Field {
+	return Field{Key: k, kind: anyField, val: v}
+}
+
+// appendFields adds fields to self with its own methods
+func appendFields(self Encoder, fields []Field) {
+	for i := range fields {
+		f := &fields[i]
+		switch f.kind {
+		case stringField:
+			self.Str(f.Key, f.str)
+		case stringsField:
+			v, _ := f.val.([]string)
+			self.Strs(f.Key, v)
+		case boolField:
+			self.Bool(f.Key, f.num != 0)
+		case intField:
+			self.Int(f.Key, int(f.num))
+		case int64Field:
+			self.Int64(f.Key, f.num)
+		case uint64Field:
+			self.Uint64(f.Key, uint64(f.num))
+		case float64Field:
+			self.Float64(f.Key, math.Float64frombits(uint64(f.num)))
+		case durField:
+			self.Dur(f.Key, time.Duration(f.num))
+		case timeField:
+			t := time.Unix(0, f.num)
+			if loc, ok := f.val.(*time.Location); ok && loc != nil {
+				t = t.In(loc)
+			}
+			self.Timestamp(f.Key, t)
+		case errField:
+			if err, ok := f.val.(error); ok && err != nil {
+				self.Str(f.Key, err.Error())
+			}
+		case bytesField:
+			v, _ := f.val.([]byte)
+			self.Bytes(f.Key, v)
+		case stringerField:
+			v, _ := f.val.(fmt.Stringer)
+			self.Stringer(f.Key, v)
+		case anyField:
+			self.Any(f.Key, f.val)
+		}
+	}
+}
+
+// Log writes a line of level with msg and fields, like tl.Info().Fields(fields...).Msg(msg)
+func (tl *TLog) Log(level int, msg string, fields ...Field) {
+	tl.WithLevel(level).Fields(fields...).Msg(msg)
+}
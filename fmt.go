@@ -0,0 +1,60 @@
+package tlog
+
+import (
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Fmt appends the formatted value right into buf, these helpers fix it up in
+// place so that no temporary buffer is allocated.
+
+// dropField removes a field appended since mark, e.g. an empty Fmt value
+// with OmitEmpty. The previous field must have been closed before mark.
+func (e *encoder) dropField(mark, nspans int) {
+	e.buf = e.buf[:mark]
+	e.nspans = nspans
+	e.fieldStart = -1
+	e.maskFunc = nil
+}
+
+// redactFrom redacts the string value buf[start:] of the field k
+func (e *encoder) redactFrom(k string, start int) {
+	if e.tl.redact == nil {
+		return
+	}
+	e.maskFunc = nil
+	v := e.buf[start:]
+	s := *(*string)(unsafe.Pointer(&v))
+	if r := e.tl.redact.str(k, s); r != s {
+		e.buf = append(e.buf[:start], r...)
+	}
+}
+
+// escapeFrom escapes buf[start:] like appendString, it's copied only if
+// something has to be escaped
+func (e *encoder) escapeFrom(start int) {
+	if !needsEscape(e.buf[start:]) {
+		return
+	}
+	e.scratch = append(e.scratch[:0], e.buf[start:]...)
+	e.buf = e.buf[:start]
+	e.appendString(*(*string)(unsafe.Pointer(&e.scratch)))
+}
+
+func needsEscape(b []byte) bool {
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			if !noEscapeTable[b[i]] {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package tlog
+
+import (
+	"strings"
+)
+
+// initHeaders pre-encodes the time key and the level key/value of every level
+// for tl.format, so that renaming them costs nothing per line.
+func (tl *TLog) initHeaders(opt *Options) {
+	tl.msgKey = opt.msgKey
+	defs := levelDefs()
+	n := 0 // up to the highest registered level
+	for i, d := range defs {
+		if len(d.Name) != 0 {
+			n = i + 1
+		}
+	}
+	values := make([]string, n)
+	for i := range values {
+		values[i] = defs[i].Name
+		if v, ok := opt.levelValues[1<<i]; ok {
+			values[i] = v
+		}
+	}
+
+	tl.levelFields = make([][]byte, len(values))
+	nop := &TLog{tlogCore: &tlogCore{}} // no tracked keys etc.
+	switch tl.format {
+	case FormatJson:
+		e := encoderJson{encoder: encoder{buf: []byte{'{'}, tl: nop, fieldStart: -1}}
+		e.appendKey(opt.timeKey)
+		tl.timeKey = append([]byte{}, e.buf[1:]...)
+		for i, v := range values {
+			if len(v) == 0 {
+				continue
+			}
+			e.buf = append(e.buf[:0], '{')
+			e.Str(opt.levelKey, v)
+			tl.levelFields[i] = append([]byte{','}, e.buf[1:]...)
+		}
+	case FormatText:
+		for i, v := range values {
+			if len(v) == 0 {
+				continue
+			}
+			tl.levelFields[i] = append([]byte{' '}, v...)
+		}
+	case FormatLogfmt:
+		e := encoderText{encoder: encoder{tl: nop, fieldStart: -1}, logfmt: true, valStart: -1}
+		e.appendLogfmtKey(opt.timeKey)
+		e.buf = append(e.buf, '=')
+		tl.timeKey = e.buf
+		for i, v := range values {
+			if len(v) == 0 {
+				continue
+			}
+			e = encoderText{encoder: encoder{tl: nop, fieldStart: -1}, logfmt: true, valStart: -1}
+			e.Str(opt.levelKey, v)
+			e.closeLogfmtValue()
+			tl.levelFields[i] = e.buf
+		}
+	case FormatConsole:
+		width := 0
+		for i, v := range values {
+			values[i] = strings.ToUpper(v)
+			if len(v) > width {
+				width = len(v)
+			}
+		}
+		for i, v := range values {
+			if len(v) == 0 {
+				continue
+			}
+			b := []byte{' '}
+			color := tl.consoleColor && len(defs[i].Color) != 0
+			if color {
+				b = append(b, defs[i].Color...)
+			}
+			b = append(b, v...)
+			if color {
+				b = append(b, colorReset...)
+			}
+			for n := len(v); n < width; n++ {
+				b = append(b, ' ')
+			}
+			tl.levelFields[i] = b
+		}
+	case FormatCBOR:
+		e := encoderCbor{encoder: encoder{tl: nop, fieldStart: -1}}
+		e.appendKey(opt.timeKey)
+		tl.timeKey = e.buf
+		for i, v := range values {
+			if len(v) == 0 {
+				continue
+			}
+			e = encoderCbor{encoder: encoder{tl: nop, fieldStart: -1}}
+			e.appendKey(opt.levelKey)
+			e.appendString(v)
+			tl.levelFields[i] = e.buf
+		}
+	}
+}
+
+// appendLevelField appends the pre-encoded level field
+func (e *encoder) appendLevelField() {
+	if i := levelIndex(e.level); i < len(e.tl.levelFields) {
+		e.buf = append(e.buf, e.tl.levelFields[i]...)
+	}
+}
@@ -0,0 +1,37 @@
+package tlog
+
+import "strings"
+
+// Hook is run on every record before it's written, see Hooks. It may add
+// fields to e or veto the line with e.Discard().
+//
+// msg is the message given to Msg/Msgf (redacted if Redact is used), empty
+// if the line ends with Go.
+type Hook interface {
+	Run(e Encoder, level int, msg string)
+}
+
+// HookFunc is an adapter to use an ordinary function as a Hook
+type HookFunc func(e Encoder, level int, msg string)
+
+func (f HookFunc) Run(e Encoder, level int, msg string) {
+	f(e, level, msg)
+}
+
+// runHooks must be called by Go before the line is closed
+func (e *encoder) runHooks(self Encoder) {
+	if len(e.tl.hooks) == 0 {
+		return
+	}
+	msg := e.msg
+	if e.tl.redact != nil {
+		msg = e.tl.redact.value(msg)
+	}
+	msg = strings.Clone(msg) // a hook may keep it, the one of Msgf is reused
+	for _, h := range e.tl.hooks {
+		h.Run(self, e.level, msg)
+		if e.discarded {
+			return
+		}
+	}
+}
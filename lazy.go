@@ -0,0 +1,47 @@
+package tlog
+
+// A field evaluated by Go only if the line is going to be written, see
+// Encoder.Func and Encoder.LazyStr
+type lazyField struct {
+	k  string
+	f  func() string
+	fn func(e Encoder)
+}
+
+// Enabled returns true if the lines of level are written, to guard the
+// computation of arguments
+func (tl *TLog) Enabled(level int) bool {
+	return int(tl.level.Load())&level != 0
+}
+
+// keep must be called by Go once the hooks ran and the last field is
+// closed, it returns false if the line won't be written: discarded, rate
+// limited or a repeat suppressed by Dedup
+func (e *encoder) keep() bool {
+	e.endTracking()
+	if e.discarded {
+		return false
+	}
+	if e.tl.limiter != nil && !e.internal && !e.tl.limiter.allow(e) {
+		e.tl.stats.rateLimited.Add(1)
+		e.discarded = true
+		return false
+	}
+	if e.tl.dedup != nil && e.tl.dedup.suppress(e) {
+		e.discarded = true
+		return false
+	}
+	return true
+}
+
+func (e *encoder) runLazy(self Encoder) {
+	for i := range e.lazy {
+		if l := e.lazy[i]; l.fn != nil {
+			l.fn(self)
+		} else {
+			self.Str(l.k, l.f())
+		}
+		e.lazy[i] = lazyField{}
+	}
+	e.lazy = e.lazy[:0]
+}
@@ -0,0 +1,114 @@
+package tlog
+
+import (
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// LevelDef describes a level, see RegisterLevel
+type LevelDef struct {
+	Name  string // value of the level field, e.g. "audit"
+	Rank  int    // severity, the built-in levels are trace 0, debug 10 ... panic 60
+	Color string // ANSI sequence for FormatConsole, e.g. "\x1b[35m"
+	File  string // file name for WriteToFileSeparate, Name if empty
+}
+
+const maxLevels = 63 // bits of a positive int
+
+var levelRegistry = struct {
+	mtx  sync.RWMutex
+	defs [maxLevels]LevelDef // indexed by level bit, Name is empty if not registered
+}{
+	defs: [maxLevels]LevelDef{
+		{Name: "debug", Rank: 10, Color: "\x1b[36m"},
+		{Name: "info", Rank: 20, Color: "\x1b[32m"},
+		{Name: "warn", Rank: 30, Color: "\x1b[33m"},
+		{Name: "error", Rank: 40, Color: "\x1b[31m"},
+		{Name: "fatal", Rank: 50, Color: "\x1b[1;31m"},
+		{Name: "panic", Rank: 60, Color: "\x1b[1;31m"},
+		{Name: "trace", Rank: 0, Color: "\x1b[90m"},
+	},
+}
+
+// RegisterLevel adds a custom level, lvl is a single bit above TraceLevel,
+// e.g. RegisterLevel(1<<7, LevelDef{Name: "audit", Rank: 45}).
+// Levels must be registered before New and NewWriteToFileSeparate, the
+// loggers created earlier don't know them. The custom levels are enabled by
+// default, unlike TraceLevel.
+func RegisterLevel(lvl int, def LevelDef) {
+	if lvl <= TraceLevel || lvl&(lvl-1) != 0 || len(def.Name) == 0 {
+		panic("tlog:RegisterLevel param is illegal")
+	}
+	levelRegistry.mtx.Lock()
+	defer levelRegistry.mtx.Unlock()
+	i := levelIndex(lvl)
+	for j, d := range levelRegistry.defs {
+		if j != i && strings.EqualFold(d.Name, def.Name) {
+			panic("tlog:RegisterLevel name is in use")
+		}
+	}
+	if len(levelRegistry.defs[i].Name) != 0 {
+		panic("tlog:RegisterLevel level is in use")
+	}
+	levelRegistry.defs[i] = def
+}
+
+// levelDefs returns a copy of the registry
+func levelDefs() [maxLevels]LevelDef {
+	levelRegistry.mtx.RLock()
+	defer levelRegistry.mtx.RUnlock()
+	return levelRegistry.defs
+}
+
+// levelIndex returns the bit position of lvl
+func levelIndex(lvl int) int {
+	return bits.TrailingZeros(uint(lvl))
+}
+
+// customLevels returns the mask of the registered custom levels
+func customLevels() int {
+	mask := 0
+	for i, d := range levelDefs() {
+		if 1<<i > TraceLevel && len(d.Name) != 0 {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// LevelName returns the name of lvl, "" if lvl isn't a level
+func LevelName(lvl int) string {
+	if lvl <= 0 || lvl&(lvl-1) != 0 {
+		return ""
+	}
+	return levelDefs()[levelIndex(lvl)].Name
+}
+
+// ParseLevel returns the level named name (case-insensitive), 0 if none
+func ParseLevel(name string) int {
+	for i, d := range levelDefs() {
+		if len(d.Name) != 0 && strings.EqualFold(d.Name, name) {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// LevelsFrom returns the levels ranked at least as lvl, e.g.
+// LevelsFrom(WarnLevel) is WarnLevel|ErrorLevel|FatalLevel|PanicLevel plus
+// the custom levels ranked above warn.
+func LevelsFrom(lvl int) int {
+	defs := levelDefs()
+	if lvl <= 0 || lvl&(lvl-1) != 0 || len(defs[levelIndex(lvl)].Name) == 0 {
+		return 0
+	}
+	rank := defs[levelIndex(lvl)].Rank
+	mask := 0
+	for i, d := range defs {
+		if len(d.Name) != 0 && d.Rank >= rank {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
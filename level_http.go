@@ -0,0 +1,120 @@
+package tlog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// LevelHandler returns an http.Handler to view and change the levels of tl
+// and of its named loggers, e.g. mux.Handle("/admin/log/level", tl.LevelHandler())
+//
+// GET returns the state as JSON:
+//
+//	{"level":"info","overrides":{"*":"info","db":"debug"},
+//	 "loggers":{"db.pool":"debug"},"expires":{"db":"2026-10-19T10:00:00Z"}}
+//
+// PUT {"logger":"db","level":"debug","duration":"10m"} sets the override of
+// db for 10 minutes, see SetLevelOverrideFor. logger defaults to "*", an
+// empty level removes the override, without duration it's permanent. The
+// response is the new state.
+func (tl *TLog) LevelHandler() http.Handler {
+	return &levelHandler{tl: tl}
+}
+
+type levelHandler struct {
+	tl *TLog
+}
+
+type levelStatus struct {
+	Level     string               `json:"level"`
+	Overrides map[string]string    `json:"overrides"`
+	Loggers   map[string]string    `json:"loggers"`
+	Expires   map[string]time.Time `json:"expires,omitempty"`
+}
+
+type levelRequest struct {
+	Logger   string `json:"logger"`
+	Level    string `json:"level"`
+	Duration string `json:"duration"`
+}
+
+func (h *levelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		if err := h.put(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.status())
+}
+
+func (h *levelHandler) put(r *http.Request) error {
+	var req levelRequest
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		return err
+	}
+	if len(req.Logger) == 0 {
+		req.Logger = "*"
+	}
+	if len(req.Level) == 0 {
+		h.tl.ClearLevelOverride(req.Logger)
+		return nil
+	}
+	mask, err := parseLevelMask(req.Level)
+	if err != nil {
+		return err
+	}
+	if len(req.Duration) == 0 {
+		h.tl.SetLevelOverride(req.Logger, mask)
+		return nil
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		return errBadDuration
+	}
+	h.tl.SetLevelOverrideFor(req.Logger, mask, d)
+	return nil
+}
+
+var errBadDuration = errors.New(`tlog: duration must be positive, e.g. "10m"`)
+
+func (h *levelHandler) status() *levelStatus {
+	ls := h.tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	st := &levelStatus{
+		Level:     formatLevelMask(ls.resolve(h.tl.name)),
+		Overrides: make(map[string]string, len(ls.overrides)),
+		Loggers:   make(map[string]string, len(ls.loggers)),
+	}
+	for k, v := range ls.overrides {
+		st.Overrides[k] = formatLevelMask(v)
+	}
+	for name := range ls.loggers {
+		if len(name) != 0 {
+			st.Loggers[name] = formatLevelMask(ls.resolve(name))
+		}
+	}
+	if len(ls.temps) != 0 {
+		st.Expires = make(map[string]time.Time, len(ls.temps))
+		for k, t := range ls.temps {
+			st.Expires[k] = t.expires
+		}
+	}
+	return st
+}
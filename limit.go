@@ -0,0 +1,28 @@
+package tlog
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// Marker appended to a value cut by MaxFieldBytes/MaxLineBytes, the record
+// gets a `truncated` field too
+const truncatedMarker = "…(truncated "
+
+// truncateText cuts s to fit in n bytes with the …(truncated N bytes) marker,
+// on a UTF-8 boundary. Only the marker is left if n is too small for it.
+func truncateText(s string, n int) string {
+	n -= len(truncatedMarker) + len(" bytes)") + len(strconv.Itoa(len(s)))
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	b := make([]byte, 0, n+len(truncatedMarker)+16)
+	b = append(b, s[:n]...)
+	b = append(b, truncatedMarker...)
+	b = strconv.AppendInt(b, int64(len(s)-n), 10)
+	b = append(b, " bytes)"...)
+	return string(b)
+}
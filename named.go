@@ -0,0 +1,276 @@
+package tlog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// levelState holds the level overrides of the named loggers, shared by the
+// loggers of a New
+type levelState struct {
+	mtx       sync.Mutex
+	base      int              // Level option
+	overrides map[string]int   // logger name or "*" -> level mask
+	loggers   map[string]*TLog // by name, "" is the root logger
+	temps     map[string]*tempOverride
+}
+
+// tempOverride restores the previous override of a logger when it expires
+type tempOverride struct {
+	prev    int
+	hadPrev bool
+	expires time.Time
+	timer   *time.Timer
+}
+
+// resolve returns the level mask of the logger name: the override of name or
+// of its closest parent, else the "*" override, else the Level option
+func (ls *levelState) resolve(name string) int {
+	for n := name; len(n) != 0; {
+		if m, ok := ls.overrides[n]; ok {
+			return m
+		}
+		i := strings.LastIndexByte(n, '.')
+		if i < 0 {
+			break
+		}
+		n = n[:i]
+	}
+	if m, ok := ls.overrides["*"]; ok {
+		return m
+	}
+	return ls.base
+}
+
+// update stores the resolved level of every logger, ls.mtx must be held
+func (ls *levelState) update() {
+	for name, tl := range ls.loggers {
+		tl.level.Store(int64(ls.resolve(name)))
+	}
+}
+
+// Named returns the logger of the module name, its lines have a `logger`
+// field. Nested names are joined by '.', tl.Named("db").Named("pool") is
+// "db.pool". The loggers of the same name are the same.
+func (tl *TLog) Named(name string) *TLog {
+	if len(name) == 0 || name == "*" {
+		panic("tlog:Named param is illegal")
+	}
+	if len(tl.name) != 0 {
+		name = tl.name + "." + name
+	}
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	if l, ok := ls.loggers[name]; ok {
+		return l
+	}
+	l := &TLog{tlogCore: tl.tlogCore, name: name}
+	l.level.Store(int64(ls.resolve(name)))
+	ls.loggers[name] = l
+	return l
+}
+
+// Name returns the name of the logger, "" for the root logger
+func (tl *TLog) Name() string {
+	return tl.name
+}
+
+// Level returns the mask of the levels enabled for tl
+func (tl *TLog) Level() int {
+	return int(tl.level.Load())
+}
+
+// SetLevel changes the level mask of the loggers without override, same as
+// SetLevelOverride("*", mask)
+func (tl *TLog) SetLevel(mask int) {
+	tl.SetLevelOverride("*", mask)
+}
+
+// SetLevelOverride sets the level mask of the logger name and of its children
+// without their own override, "*" is the default of all loggers
+func (tl *TLog) SetLevelOverride(name string, mask int) {
+	if len(name) == 0 {
+		panic("tlog:SetLevelOverride param is illegal")
+	}
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	ls.stopTemp(name)
+	ls.setOverride(name, mask, true)
+}
+
+// SetLevelOverrideFor sets the override of the logger name for d, then the
+// previous one is restored, e.g. debug for 10 minutes
+func (tl *TLog) SetLevelOverrideFor(name string, mask int, d time.Duration) {
+	if len(name) == 0 || d <= 0 {
+		panic("tlog:SetLevelOverrideFor param is illegal")
+	}
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	t := &tempOverride{expires: time.Now().Add(d)}
+	if old, ok := ls.temps[name]; ok { // extended, keep the original one
+		old.timer.Stop()
+		t.prev, t.hadPrev = old.prev, old.hadPrev
+	} else {
+		t.prev, t.hadPrev = ls.overrides[name]
+	}
+	t.timer = time.AfterFunc(d, func() {
+		ls.mtx.Lock()
+		defer ls.mtx.Unlock()
+		if ls.temps[name] == t {
+			delete(ls.temps, name)
+			ls.setOverride(name, t.prev, t.hadPrev)
+		}
+	})
+	if ls.temps == nil {
+		ls.temps = make(map[string]*tempOverride)
+	}
+	ls.temps[name] = t
+	ls.setOverride(name, mask, true)
+}
+
+// ClearLevelOverride removes the override of the logger name
+func (tl *TLog) ClearLevelOverride(name string) {
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	ls.stopTemp(name)
+	if _, ok := ls.overrides[name]; ok {
+		ls.setOverride(name, 0, false)
+	}
+}
+
+// SetLevelOverrides replaces all the overrides, see ParseLevelOverrides
+func (tl *TLog) SetLevelOverrides(overrides map[string]int) {
+	m := make(map[string]int, len(overrides))
+	for k, v := range overrides {
+		if len(k) == 0 {
+			panic("tlog:SetLevelOverrides param is illegal")
+		}
+		m[k] = v
+	}
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	for name := range ls.temps {
+		ls.stopTemp(name)
+	}
+	ls.overrides = m
+	ls.update()
+}
+
+// setOverride sets (or removes if !ok) the override of name, the map is copied
+// as it may have been returned by Options. ls.mtx must be held.
+func (ls *levelState) setOverride(name string, mask int, ok bool) {
+	overrides := make(map[string]int, len(ls.overrides)+1)
+	for k, v := range ls.overrides {
+		overrides[k] = v
+	}
+	if ok {
+		overrides[name] = mask
+	} else {
+		delete(overrides, name)
+	}
+	ls.overrides = overrides
+	ls.update()
+}
+
+// stopTemp cancels the expiry of the temporary override of name
+func (ls *levelState) stopTemp(name string) {
+	if t, ok := ls.temps[name]; ok {
+		t.timer.Stop()
+		delete(ls.temps, name)
+	}
+}
+
+// LevelOverrides returns a copy of the overrides
+func (tl *TLog) LevelOverrides() map[string]int {
+	ls := tl.levels
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	m := make(map[string]int, len(ls.overrides))
+	for k, v := range ls.overrides {
+		m[k] = v
+	}
+	return m
+}
+
+// ParseLevelOverrides parses "db=debug, db.pool=warn, *=info", a level name
+// enables it and the levels ranked above, "info|error" enables exactly info
+// and error, "off" disables all.
+func ParseLevelOverrides(spec string) (map[string]int, error) {
+	overrides := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		name, lvl, ok := strings.Cut(part, "=")
+		name, lvl = strings.TrimSpace(name), strings.TrimSpace(lvl)
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("tlog: bad level override %q", part)
+		}
+		mask, err := parseLevelMask(lvl)
+		if err != nil {
+			return nil, err
+		}
+		overrides[name] = mask
+	}
+	return overrides, nil
+}
+
+// parseLevelMask parses the level of an override: a level name enables it
+// and the levels ranked above, "a|b" enables exactly a and b, "off" none.
+func parseLevelMask(s string) (int, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	if strings.IndexByte(s, '|') < 0 {
+		if lvl := ParseLevel(s); lvl != 0 {
+			return LevelsFrom(lvl), nil
+		}
+		return 0, fmt.Errorf("tlog: unknown level %q", s)
+	}
+	mask := 0
+	for _, name := range strings.Split(s, "|") {
+		lvl := ParseLevel(strings.TrimSpace(name))
+		if lvl == 0 {
+			return 0, fmt.Errorf("tlog: unknown level %q", name)
+		}
+		mask |= lvl
+	}
+	return mask, nil
+}
+
+// formatLevelMask is the reverse of parseLevelMask, the unregistered bits of
+// mask are ignored
+func formatLevelMask(mask int) string {
+	defs := levelDefs()
+	type level struct {
+		bit  int
+		rank int
+	}
+	var levels []level
+	for i, d := range defs {
+		if len(d.Name) != 0 && mask&(1<<i) != 0 {
+			levels = append(levels, level{1 << i, d.Rank})
+		}
+	}
+	if len(levels) == 0 {
+		return "off"
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].rank < levels[j].rank })
+	if from := levels[0].bit; LevelsFrom(from)&^mask == 0 {
+		return defs[levelIndex(from)].Name
+	}
+	names := make([]string, len(levels))
+	for i, l := range levels {
+		names[i] = defs[levelIndex(l.bit)].Name
+	}
+	return strings.Join(names, "|")
+}
@@ -2,6 +2,8 @@ package tlog
 
 import (
 	"encoding/json"
+	"path"
+	"time"
 )
 
 type Options struct {
@@ -11,10 +13,40 @@ type Options struct {
 	format int
 
 	timeFormat int
+	timeLayout string
 
-	level int
+	durUnit    time.Duration
+	durInteger bool
+	location   *time.Location
 
-	writer Writer
+	coarseClock time.Duration
+
+	sampler      Sampler
+	sampleReport time.Duration
+
+	dedupWindow time.Duration
+	dedupKeys   []string
+
+	rateLimit float64
+	rateBurst int
+	rateField string
+
+	redactRules []RedactRule
+
+	hooks []Hook
+
+	ctxExtractors []ContextExtractor
+
+	maxFieldBytes int
+	maxLineBytes  int
+
+	level          int
+	levelOverrides map[string]int
+
+	writer        Writer
+	fallback      Writer
+	errorHandler  ErrorHandlerFunc
+	errorInterval time.Duration
 
 	// for output file
 	logDir        string
@@ -23,61 +55,357 @@ type Options struct {
 
 	anyMarshalFunc AnyMarshalFuncT
 
-	// for simple post
+	// core field names
+	timeKey     string
+	levelKey    string
+	msgKey      string
+	loggerKey   string
+	levelValues map[int]string
+
+	// for console format
+	consoleColor     int
+	consoleMultiLine bool
+
+	// for simple post/splunk hec
 	postUrl string
+
+	// for splunk hec
+	hecToken         string
+	hecHost          string
+	hecSource        string
+	hecSourceType    string
+	hecChannel       string
+	hecBatchSize     int
+	hecFlushInterval time.Duration
+	hecAckTimeout    time.Duration
+
+	err error // of the first option given an illegal param
 }
 
+// Option sets an option of New and of the writers. An option given an
+// illegal param records an *OptionError with Options.fail, it's returned by
+// NewE and the Open* writer constructors.
 type Option func(*Options)
 
-func setOptions(optL ...Option) *Options {
+// fail records err unless an earlier option failed
+func (o *Options) fail(err error) {
+	if o.err == nil {
+		o.err = err
+	}
+}
+
+func setOptions(optL ...Option) (*Options, error) {
 	opts := &Options{
 		omitEmpty:      true,
 		format:         FormatJson,
-		level:          AllLevel,
+		level:          AllLevel | customLevels(), // TraceLevel is opt-in
 		writer:         NewWriteToConsole(),
 		timeFormat:     HumanReadableTimeMs,
+		durUnit:        time.Millisecond,
 		logDir:         "logs",
 		logFilePrefix:  "tlog",
 		fileStoreMode:  DailySplit,
 		anyMarshalFunc: json.Marshal,
+		timeKey:        "time",
+		levelKey:       "level",
+		msgKey:         "msg",
+		loggerKey:      "logger",
+
+		hecSourceType:    "_json",
+		hecBatchSize:     100,
+		hecFlushInterval: time.Second,
+		hecAckTimeout:    30 * time.Second,
 	}
 
 	for _, opt := range optL {
 		opt(opts)
 	}
-	return opts
+	if opts.err != nil {
+		return nil, opts.err
+	}
+	return opts, nil
+}
+
+// Sample the lines before they are encoded, see BasicSampler, BurstSampler
+// and LevelSampler
+func SetSampler(s Sampler) Option {
+	return func(o *Options) {
+		if s == nil {
+			o.fail(optionError("SetSampler"))
+			return
+		}
+		o.sampler = s
+	}
+}
+
+// Write a warn line with the number of lines dropped by the sampler every
+// interval (if any). Call TLog.Close to stop it
+func SampleReport(interval time.Duration) Option {
+	return func(o *Options) {
+		if interval <= 0 {
+			o.fail(optionError("SampleReport"))
+			return
+		}
+		o.sampleReport = interval
+	}
+}
+
+// Suppress repeated lines, a line with the same level and msg (and the same
+// values of keys) as a line written within the last window is not written.
+// When the window closes, one line with a `repeated` count is written instead.
+// The lines without msg (ended with Go) are never suppressed.
+// Call TLog.Close to stop the background goroutine
+func Dedup(window time.Duration, keys ...string) Option {
+	return func(o *Options) {
+		if window <= 0 {
+			o.fail(optionError("Dedup"))
+			return
+		}
+		o.dedupWindow = window
+		o.dedupKeys = keys
+	}
+}
+
+// Limit the lines per key to perSecond with bursts of burst lines, using a
+// token bucket per key. The key is set by Encoder.RateKey or is the value of
+// the RateLimitField field, lines without a key are not limited.
+// Dropped lines are counted in Stats().RateLimited
+func RateLimit(perSecond float64, burst int) Option {
+	return func(o *Options) {
+		if perSecond <= 0 || burst < 1 {
+			o.fail(optionError("RateLimit"))
+			return
+		}
+		o.rateLimit = perSecond
+		o.rateBurst = burst
+	}
+}
+
+// Rate limit by the value of field k, e.g. "client_ip"
+func RateLimitField(k string) Option {
+	return func(o *Options) {
+		if len(k) == 0 {
+			o.fail(optionError("RateLimitField"))
+			return
+		}
+		o.rateField = k
+	}
+}
+
+// Run the hooks in order on every record before it's written, a hook may add
+// fields or veto the line. Hooks may be given more than once.
+func Hooks(h ...Hook) Option {
+	return func(o *Options) {
+		for _, v := range h {
+			if v == nil {
+				o.fail(optionError("Hooks"))
+				return
+			}
+		}
+		o.hooks = append(o.hooks, h...)
+	}
+}
+
+// Fields added by Encoder.Ctx, e.g. ExtractValue(requestIDKey{}, "request_id")
+// and ExtractDeadline("deadline_ms"). Extractors may be given more than once.
+func ContextExtractors(f ...ContextExtractor) Option {
+	return func(o *Options) {
+		for _, v := range f {
+			if v == nil {
+				o.fail(optionError("ContextExtractors"))
+				return
+			}
+		}
+		o.ctxExtractors = append(o.ctxExtractors, f...)
+	}
+}
+
+// Mask the values of sensitive fields before they're encoded, in every format.
+// Values of redacted keys are masked whatever their type, value regexps apply
+// to the strings of Str/Strs/Fmt/FastStr, the msg and the strings nested in
+// Any/RawJSON. Redact may be given more than once.
+func Redact(rules ...RedactRule) Option {
+	return func(o *Options) {
+		for _, r := range rules {
+			if len(r.Keys) == 0 && len(r.KeyGlobs) == 0 && r.ValueRegexp == nil {
+				o.fail(optionError("Redact"))
+				return
+			}
+			for _, g := range r.KeyGlobs {
+				if _, err := path.Match(g, ""); err != nil {
+					o.fail(optionError("Redact"))
+					return
+				}
+			}
+		}
+		o.redactRules = append(o.redactRules, rules...)
+	}
+}
+
+// Cut the values longer than n bytes on a UTF-8 boundary, a cut value is
+// written as a string ending with `…(truncated N bytes)` and the record gets a
+// `truncated:true` field.
+func MaxFieldBytes(n int) Option {
+	return func(o *Options) {
+		if n < 1 {
+			o.fail(optionError("MaxFieldBytes"))
+			return
+		}
+		o.maxFieldBytes = n
+	}
+}
+
+// Cut the values that would make the line longer than about n bytes, like
+// MaxFieldBytes. The keys and the markers aren't cut, so a line with many
+// fields past the limit may still be a bit longer than n.
+func MaxLineBytes(n int) Option {
+	return func(o *Options) {
+		if n < 1 {
+			o.fail(optionError("MaxLineBytes"))
+			return
+		}
+		o.maxLineBytes = n
+	}
+}
+
+// The unit of Encoder.Dur values, time.Millisecond by default
+func DurationUnit(u time.Duration) Option {
+	return func(o *Options) {
+		if u <= 0 {
+			o.fail(optionError("DurationUnit"))
+			return
+		}
+		o.durUnit = u
+	}
+}
+
+// Write Encoder.Dur values as integers (truncated) instead of floats
+func DurationInteger(v bool) Option {
+	return func(o *Options) {
+		o.durInteger = v
+	}
 }
 
 // If you don't want to output anything, you can use io.Discard
 func SetWriter(w Writer) Option {
 	return func(o *Options) {
 		if w == nil {
-			panic("tlog:SetWriter param is illegal")
+			o.fail(optionError("SetWriter"))
+			return
 		}
 		o.writer = w
 	}
 }
 
+// Write the lines the writer failed to write to w, e.g. NewWriteToStderr()
+func FallbackWriter(w Writer) Option {
+	return func(o *Options) {
+		if w == nil {
+			o.fail(optionError("FallbackWriter"))
+			return
+		}
+		o.fallback = w
+	}
+}
+
+// Pass the failures of the writer to f, at most once per interval. The
+// failures are counted in Stats().WriteFailed and Stats().Lost anyway
+func ErrorHandler(f ErrorHandlerFunc, interval time.Duration) Option {
+	return func(o *Options) {
+		if f == nil || interval < 0 {
+			o.fail(optionError("ErrorHandler"))
+			return
+		}
+		o.errorHandler = f
+		o.errorInterval = interval
+	}
+}
+
 // Set prefix `time` format
 func TimeFormat(v int) Option {
-	if v < 1 {
-		panic("tlog:TimeFormat param is illegal")
-	}
 	return func(o *Options) {
+		if v < 1 || v >= CustomTimeLayout {
+			o.fail(optionError("TimeFormat"))
+			return
+		}
 		o.timeFormat = v
 	}
 }
 
-// json/text
-func Format(v int) Option {
-	if v != FormatJson && v != FormatText {
-		panic("tlog:Format param is illegal")
+// Set prefix `time` format to a time.Format layout, e.g. "Jan _2 15:04:05.000"
+func TimeLayout(layout string) Option {
+	return func(o *Options) {
+		if len(layout) == 0 {
+			o.fail(optionError("TimeLayout"))
+			return
+		}
+		o.timeFormat = CustomTimeLayout
+		o.timeLayout = layout
 	}
+}
+
+// Time zone of the prefix `time`, also used for the daily rollover of files.
+// Default is local time
+func TimeZone(loc *time.Location) Option {
 	return func(o *Options) {
+		if loc == nil {
+			o.fail(optionError("TimeZone"))
+			return
+		}
+		o.location = loc
+	}
+}
+
+// Read the time from a clock updated every resolution (e.g. time.Millisecond)
+// by a background goroutine instead of calling time.Now() per line, the
+// formatted date/time is also cached per second.
+// Call TLog.Close to stop the goroutine
+func CoarseClock(resolution time.Duration) Option {
+	return func(o *Options) {
+		if resolution <= 0 {
+			o.fail(optionError("CoarseClock"))
+			return
+		}
+		o.coarseClock = resolution
+	}
+}
+
+// Same as TimeZone(time.UTC)
+func UTC() Option {
+	return TimeZone(time.UTC)
+}
+
+// json/text/logfmt/console/cbor
+func Format(v int) Option {
+	return func(o *Options) {
+		if v < FormatJson || v > FormatCBOR {
+			o.fail(optionError("Format"))
+			return
+		}
 		o.format = v
 	}
 }
 
+// Mask of the enabled levels, e.g. Level(LevelsFrom(InfoLevel))
+func Level(v int) Option {
+	return func(o *Options) {
+		o.level = v
+	}
+}
+
+// Level overrides of the named loggers, e.g. "db=debug, db.pool=warn, *=info",
+// see TLog.Named and ParseLevelOverrides
+func LevelOverrides(spec string) Option {
+	return func(o *Options) {
+		overrides, err := ParseLevelOverrides(spec)
+		if err != nil {
+			o.fail(&OptionError{Option: "LevelOverrides", Err: err})
+			return
+		}
+		o.levelOverrides = overrides
+	}
+}
+
 // for json:string,array
 func OmitEmpty(v bool) Option {
 	return func(o *Options) {
@@ -85,47 +413,198 @@ func OmitEmpty(v bool) Option {
 	}
 }
 
+// Rename the `time` field, e.g. "@timestamp"
+func TimeFieldName(v string) Option {
+	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("TimeFieldName"))
+			return
+		}
+		o.timeKey = v
+	}
+}
+
+// Rename the `level` field, e.g. "severity"
+func LevelFieldName(v string) Option {
+	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("LevelFieldName"))
+			return
+		}
+		o.levelKey = v
+	}
+}
+
+// Rename the `msg` field, e.g. "message"
+func MessageFieldName(v string) Option {
+	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("MessageFieldName"))
+			return
+		}
+		o.msgKey = v
+	}
+}
+
+// Rename the `logger` field of the named loggers
+func LoggerFieldName(v string) Option {
+	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("LoggerFieldName"))
+			return
+		}
+		o.loggerKey = v
+	}
+}
+
+// Set the value of the level field, e.g. LevelValue(WarnLevel, "WARNING")
+func LevelValue(lvl int, v string) Option {
+	return func(o *Options) {
+		if lvl <= 0 || lvl&(lvl-1) != 0 || len(v) == 0 {
+			o.fail(optionError("LevelValue"))
+			return
+		}
+		if o.levelValues == nil {
+			o.levelValues = make(map[int]string)
+		}
+		o.levelValues[lvl] = v
+	}
+}
+
+// for console format, force colors on or off.
+// By default colors are used only if stdout is a terminal and NO_COLOR is not set
+func ConsoleColor(v bool) Option {
+	return func(o *Options) {
+		if v {
+			o.consoleColor = consoleColorOn
+		} else {
+			o.consoleColor = consoleColorOff
+		}
+	}
+}
+
+// for console format, render `stack`/`stacktrace` fields and RawJSON on the
+// following lines
+func ConsoleMultiLine(v bool) Option {
+	return func(o *Options) {
+		o.consoleMultiLine = v
+	}
+}
+
 // for output file
 func LogDir(v string) Option {
-	if len(v) == 0 {
-		panic("tlog:LogDir param is illegal")
-	}
 	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("LogDir"))
+			return
+		}
 		o.logDir = v
 	}
 }
 func LogFilePrefix(v string) Option {
-	if len(v) == 0 {
-		panic("tlog:LogFilePrefix param is illegal")
-	}
 	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("LogFilePrefix"))
+			return
+		}
 		o.logFilePrefix = v
 	}
 }
 func FileStoreMode(v FileStoreModeT) Option {
-	if v < 1 {
-		panic("tlog:FileStoreMode param is illegal")
-	}
 	return func(o *Options) {
+		if v < 1 {
+			o.fail(optionError("FileStoreMode"))
+			return
+		}
 		o.fileStoreMode = v
 	}
 }
 
-// for simple post
+// for simple post/splunk hec
 func PostUrl(v string) Option {
-	if len(v) == 0 {
-		panic("tlog:PostUrl param is illegal")
-	}
 	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("PostUrl"))
+			return
+		}
 		o.postUrl = v
 	}
 }
 
 func AnyMarshalFunc(f AnyMarshalFuncT) Option {
-	if f == nil {
-		panic("tlog:AnyMarshalFuncT param is illegal")
-	}
 	return func(o *Options) {
+		if f == nil {
+			o.fail(optionError("AnyMarshalFuncT"))
+			return
+		}
 		o.anyMarshalFunc = f
 	}
 }
+
+// for splunk hec
+func HECToken(v string) Option {
+	return func(o *Options) {
+		if len(v) == 0 {
+			o.fail(optionError("HECToken"))
+			return
+		}
+		o.hecToken = v
+	}
+}
+
+// Default is os.Hostname()
+func HECHost(v string) Option {
+	return func(o *Options) {
+		o.hecHost = v
+	}
+}
+func HECSource(v string) Option {
+	return func(o *Options) {
+		o.hecSource = v
+	}
+}
+
+// Default is "_json"
+func HECSourceType(v string) Option {
+	return func(o *Options) {
+		o.hecSourceType = v
+	}
+}
+
+// Enable indexer acknowledgment, v is the channel GUID
+func HECChannel(v string) Option {
+	return func(o *Options) {
+		o.hecChannel = v
+	}
+}
+
+// Max events per request
+func HECBatchSize(v int) Option {
+	return func(o *Options) {
+		if v < 1 {
+			o.fail(optionError("HECBatchSize"))
+			return
+		}
+		o.hecBatchSize = v
+	}
+}
+func HECFlushInterval(v time.Duration) Option {
+	return func(o *Options) {
+		if v <= 0 {
+			o.fail(optionError("HECFlushInterval"))
+			return
+		}
+		o.hecFlushInterval = v
+	}
+}
+
+// Unacknowledged batches are sent again after v
+func HECAckTimeout(v time.Duration) Option {
+	return func(o *Options) {
+		if v <= 0 {
+			o.fail(optionError("HECAckTimeout"))
+			return
+		}
+		o.hecAckTimeout = v
+	}
+}
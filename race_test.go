@@ -0,0 +1,7 @@
+//go:build race
+
+package tlog
+
+// sync.Pool drops items at random under the race detector, the encoders of
+// the allocation tests are then allocated again
+const raceEnabled = true
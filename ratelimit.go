@@ -0,0 +1,109 @@
+package tlog
+
+import (
+	"sync"
+)
+
+// rateLimiter is a token bucket per key, see RateLimit
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	field string // key by the value of this field if the line has no RateKey
+
+	mtx       sync.Mutex
+	buckets   map[string]*tokenBucket
+	overflow  tokenBucket // shared by the new keys while buckets is full
+	nextSweep int64       // unix nano
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   int64 // unix nano
+}
+
+// When the map is full the buckets refilled are removed, at most once per
+// second, the new keys share the overflow bucket until there is room again.
+// Only the buckets a new one would equal are removed, a key can't get its
+// tokens back by flooding new keys.
+const rateLimitMaxKeys = 10000
+
+func newRateLimiter(rate float64, burst int, field string) *rateLimiter {
+	return &rateLimiter{
+		rate:     rate,
+		burst:    float64(burst),
+		field:    field,
+		buckets:  make(map[string]*tokenBucket),
+		overflow: tokenBucket{tokens: float64(burst)},
+	}
+}
+
+// allow returns false if the key of the line ran out of tokens, lines without
+// a key are always allowed
+func (l *rateLimiter) allow(e *encoder) bool {
+	var key []byte
+	if len(e.rateKey) > 0 {
+		e.scratch = append(e.scratch[:0], e.rateKey...)
+		e.scratch = append(e.scratch, 0)
+		key = e.scratch
+	} else if len(l.field) > 0 {
+		for i := 0; i < e.nspans; i++ {
+			if sp := e.spans[i]; sp.key == l.field && sp.end > sp.start {
+				key = e.buf[sp.start:sp.end]
+				break
+			}
+		}
+	}
+	if key == nil {
+		return true
+	}
+
+	now := e.now.UnixNano()
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	b, ok := l.buckets[string(key)]
+	if !ok {
+		if len(l.buckets) >= rateLimitMaxKeys && now >= l.nextSweep {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= rateLimitMaxKeys {
+			return l.take(&l.overflow, now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[string(key)] = b
+	}
+	return l.take(b, now)
+}
+
+// take refills b and takes a token, l.mtx must be held
+func (l *rateLimiter) take(b *tokenBucket, now int64) bool {
+	if now > b.last {
+		b.tokens = l.refilled(b, now)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) refilled(b *tokenBucket, now int64) float64 {
+	tokens := b.tokens
+	if now > b.last {
+		tokens += float64(now-b.last) / 1e9 * l.rate
+	}
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// sweep removes the buckets that are full again, l.mtx must be held
+func (l *rateLimiter) sweep(now int64) {
+	l.nextSweep = now + 1e9
+	for k, b := range l.buckets {
+		if l.refilled(b, now) >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
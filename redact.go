@@ -0,0 +1,230 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaskFunc returns the text written in place of a redacted value v
+type MaskFunc func(v string) string
+
+// RedactRule selects the values to be masked, see Redact.
+type RedactRule struct {
+	Keys        []string       // field names, case-insensitive
+	KeyGlobs    []string       // path.Match patterns of field names, e.g. "*_token"
+	ValueRegexp *regexp.Regexp // the matching parts of string values are masked
+	Mask        MaskFunc       // MaskAll if nil
+}
+
+const redactedText = "[REDACTED]"
+
+// MaskAll replaces the whole value by [REDACTED]
+func MaskAll(v string) string {
+	return redactedText
+}
+
+// MaskKeepLast replaces all but the last n characters by '*',
+// e.g. ************1111
+func MaskKeepLast(n int) MaskFunc {
+	return func(v string) string {
+		cnt := utf8.RuneCountInString(v)
+		if cnt <= n {
+			return strings.Repeat("*", cnt)
+		}
+		i := 0
+		for skip := cnt - n; skip > 0; skip-- {
+			_, size := utf8.DecodeRuneInString(v[i:])
+			i += size
+		}
+		return strings.Repeat("*", cnt-n) + v[i:]
+	}
+}
+
+type redactGlob struct {
+	pattern string
+	mask    MaskFunc
+}
+
+type redactValue struct {
+	re   *regexp.Regexp
+	mask MaskFunc
+}
+
+// redactor applies the RedactRules, it's read-only after New
+type redactor struct {
+	keys   map[string]MaskFunc // lower case
+	globs  []redactGlob
+	values []redactValue
+}
+
+func newRedactor(rules []RedactRule) *redactor {
+	r := &redactor{keys: make(map[string]MaskFunc)}
+	for _, rule := range rules {
+		mask := rule.Mask
+		if mask == nil {
+			mask = MaskAll
+		}
+		for _, k := range rule.Keys {
+			r.keys[strings.ToLower(k)] = mask
+		}
+		for _, g := range rule.KeyGlobs {
+			r.globs = append(r.globs, redactGlob{pattern: strings.ToLower(g), mask: mask})
+		}
+		if rule.ValueRegexp != nil {
+			r.values = append(r.values, redactValue{re: rule.ValueRegexp, mask: mask})
+		}
+	}
+	return r
+}
+
+// keyMask returns the mask of the values of k, nil if k isn't redacted
+func (r *redactor) keyMask(k string) MaskFunc {
+	if len(r.keys) == 0 && len(r.globs) == 0 {
+		return nil
+	}
+	k = strings.ToLower(k) // no allocation if k is lower case already
+	if m, ok := r.keys[k]; ok {
+		return m
+	}
+	for _, g := range r.globs {
+		if ok, _ := path.Match(g.pattern, k); ok {
+			return g.mask
+		}
+	}
+	return nil
+}
+
+// value masks the parts of v matching the value regexps
+func (r *redactor) value(v string) string {
+	for _, rv := range r.values {
+		v = rv.re.ReplaceAllStringFunc(v, rv.mask) // v itself if there's no match
+	}
+	return v
+}
+
+// str returns the string value v of the field k as it's to be written
+func (r *redactor) str(k, v string) string {
+	if m := r.keyMask(k); m != nil {
+		return m(v)
+	}
+	return r.value(v)
+}
+
+// json returns the JSON value b of the field k as it's to be written, the
+// keys and strings nested in b are redacted too. If b isn't valid JSON only
+// the value regexps are applied to the text.
+func (r *redactor) json(k string, b []byte) []byte {
+	if m := r.keyMask(k); m != nil {
+		return jsonString(m(jsonText(b)))
+	}
+	var v any
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil || d.More() {
+		return []byte(r.value(string(b)))
+	}
+	v, changed := r.walk(v)
+	if !changed {
+		return b
+	}
+	return jsonMarshal(v)
+}
+
+func (r *redactor) walk(v any) (any, bool) {
+	changed := false
+	switch x := v.(type) {
+	case map[string]any:
+		for k, val := range x {
+			if m := r.keyMask(k); m != nil {
+				x[k] = m(jsonText(jsonMarshal(val)))
+				changed = true
+			} else if nv, ok := r.walk(val); ok {
+				x[k] = nv
+				changed = true
+			}
+		}
+	case []any:
+		for i, val := range x {
+			if nv, ok := r.walk(val); ok {
+				x[i] = nv
+				changed = true
+			}
+		}
+	case string:
+		if s := r.value(x); s != x {
+			return s, true
+		}
+	}
+	return v, changed
+}
+
+// jsonText is the text of the JSON value b, strings are unquoted
+func jsonText(b []byte) string {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			return s
+		}
+	}
+	return string(b)
+}
+
+func jsonString(s string) []byte {
+	return jsonMarshal(s)
+}
+
+// Like json.Marshal without escaping <, > and &
+func jsonMarshal(v any) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return []byte(`"` + redactedText + `"`)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+}
+
+// redactStr must be called after appendKey(k), it returns the string value v
+// to be written
+func (e *encoder) redactStr(k, v string) string {
+	if e.tl.redact == nil {
+		return v
+	}
+	e.maskFunc = nil // masked here, with the raw value
+	return e.tl.redact.str(k, v)
+}
+func (e *encoder) redactStrs(k string, vals []string) []string {
+	if e.tl.redact == nil {
+		return vals
+	}
+	e.maskFunc = nil
+	var out []string
+	for i, v := range vals {
+		s := e.tl.redact.str(k, v)
+		if out == nil && s != v {
+			out = make([]string, len(vals))
+			copy(out, vals[:i])
+		}
+		if out != nil {
+			out[i] = s
+		}
+	}
+	if out == nil {
+		return vals
+	}
+	return out
+}
+
+// redactJSON must be called after appendKey(k), it returns the JSON value b
+// to be written
+func (e *encoder) redactJSON(k string, b []byte) []byte {
+	if e.tl.redact == nil {
+		return b
+	}
+	e.maskFunc = nil
+	return e.tl.redact.json(k, b)
+}
@@ -0,0 +1,122 @@
+package tlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sampler decides whether a line is written, it's called before any encoding
+// happens. Fatal and Panic lines are always written, they are not passed to
+// the sampler. Implementations must be safe for concurrent use.
+type Sampler interface {
+	// Sample returns true if the line of level lvl should be written
+	Sample(lvl int) bool
+}
+
+// BasicSampler writes every Nth line
+type BasicSampler struct {
+	N uint32
+
+	counter atomic.Uint32
+}
+
+func (s *BasicSampler) Sample(lvl int) bool {
+	n := s.N
+	if n <= 1 {
+		return true
+	}
+	c := s.counter.Add(1)
+	return c%n == 1
+}
+
+// BurstSampler writes Burst lines per Period, after that the lines are
+// passed to NextSampler or dropped if NextSampler is nil.
+type BurstSampler struct {
+	Burst       uint32
+	Period      time.Duration
+	NextSampler Sampler
+
+	counter atomic.Uint32
+	resetAt atomic.Int64
+}
+
+func (s *BurstSampler) Sample(lvl int) bool {
+	if s.Burst > 0 && s.Period > 0 {
+		if s.inc() <= s.Burst {
+			return true
+		}
+	}
+	if s.NextSampler == nil {
+		return false
+	}
+	return s.NextSampler.Sample(lvl)
+}
+func (s *BurstSampler) inc() uint32 {
+	now := time.Now().UnixNano()
+	resetAt := s.resetAt.Load()
+	if now > resetAt {
+		if s.resetAt.CompareAndSwap(resetAt, now+int64(s.Period)) {
+			s.counter.Store(1)
+			return 1
+		}
+	}
+	return s.counter.Add(1)
+}
+
+// LevelSampler applies a different sampler per level, levels without a
+// sampler are not sampled.
+type LevelSampler struct {
+	TraceSampler, DebugSampler, InfoSampler, WarnSampler, ErrorSampler Sampler
+}
+
+func (s *LevelSampler) Sample(lvl int) bool {
+	var sampler Sampler
+	switch lvl {
+	case TraceLevel:
+		sampler = s.TraceSampler
+	case DebugLevel:
+		sampler = s.DebugSampler
+	case InfoLevel:
+		sampler = s.InfoSampler
+	case WarnLevel:
+		sampler = s.WarnSampler
+	case ErrorLevel:
+		sampler = s.ErrorSampler
+	}
+	if sampler == nil {
+		return true
+	}
+	return sampler.Sample(lvl)
+}
+
+// sampleReport writes a summary of the lines dropped by the sampler every interval
+func (tl *TLog) sampleReport(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tl.done:
+			return
+		case <-ticker.C:
+			tl.writeSampleReport()
+		}
+	}
+}
+func (tl *TLog) writeSampleReport() {
+	var e Encoder
+	for i := range tl.sampled {
+		n := tl.sampled[i].Swap(0)
+		if n == 0 {
+			continue
+		}
+		if e == nil {
+			e = tl.encoder(WarnLevel, nil)
+		}
+		if name := LevelName(1 << i); len(name) != 0 {
+			e.Uint64("sampled_"+name, n)
+		}
+	}
+	if e != nil {
+		e.Msg("sampler dropped lines")
+	}
+}
@@ -0,0 +1,27 @@
+package tlog
+
+import "sync/atomic"
+
+// Stats are the counters of a TLog since it was created
+type Stats struct {
+	Sampled     uint64 // lines dropped by the sampler
+	RateLimited uint64 // lines dropped by the rate limiter
+	WriteFailed uint64 // lines the writer failed to write
+	Lost        uint64 // lines neither the writer nor the FallbackWriter wrote
+}
+
+type stats struct {
+	sampled     atomic.Uint64
+	rateLimited atomic.Uint64
+	writeFailed atomic.Uint64
+	lost        atomic.Uint64
+}
+
+func (tl *TLog) Stats() Stats {
+	return Stats{
+		Sampled:     tl.stats.sampled.Load(),
+		RateLimited: tl.stats.rateLimited.Load(),
+		WriteFailed: tl.stats.writeFailed.Load(),
+		Lost:        tl.stats.lost.Load(),
+	}
+}
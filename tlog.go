@@ -1,8 +1,11 @@
 package tlog
 
 import (
+	"math"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -12,34 +15,117 @@ const (
 	ErrorLevel int = 1 << 3
 	FatalLevel int = 1 << 4
 	PanicLevel int = 1 << 5
+	TraceLevel int = 1 << 6 // ranked below DebugLevel, not in AllLevel
 	AllLevel   int = (DebugLevel | InfoLevel | WarnLevel | ErrorLevel | FatalLevel | PanicLevel)
 
-	FormatJson int = 1
-	FormatText int = 2
+	FormatJson    int = 1
+	FormatText    int = 2
+	FormatLogfmt  int = 3 // strict logfmt, values are quoted when needed
+	FormatConsole int = 4 // colorized, human-friendly text for development
+	FormatCBOR    int = 5 // binary, RFC 8949
 )
 
 type TLog struct {
+	*tlogCore // shared by the named loggers
+
+	name  string       // "" for the root logger
+	level atomic.Int64 // mask of the enabled levels, see SetLevels
+}
+
+type tlogCore struct {
 	omitEmpty      bool // for json
 	format         int
-	level          int
 	timeFormat     int
+	timeLayout     string         // for CustomTimeLayout
+	location       *time.Location // nil is local time
+	clock          *coarseClock   // nil if not CoarseClock
+	timeCache      atomic.Pointer[timeHeaderCache]
 	anyMarshalFunc AnyMarshalFuncT
 
+	consoleColor     bool
+	consoleMultiLine bool
+
+	// pre-encoded for the format, see initHeaders
+	timeKey     []byte
+	levelFields [][]byte // indexed by level bit
+	msgKey      string
+	loggerKey   string
+
+	levels *levelState
+
+	sampler Sampler
+	dedup   *deduper     // nil if not Dedup
+	limiter *rateLimiter // nil if not RateLimit
+	redact  *redactor    // nil if not Redact
+	hooks   []Hook
+
+	ctxExtractors []ContextExtractor
+
+	durUnit    time.Duration
+	durInteger bool
+
+	maxFieldBytes int // 0 is unlimited
+	maxLineBytes  int
+
+	// field values to be located in the line, see encoder.trackKey
+	trackKeys []string
+	sampled   [64]atomic.Uint64 // dropped by the sampler since the last report, indexed by level bit
+	stats     stats
+
 	encoderTextPool sync.Pool
 	encoderJsonPool sync.Pool
+	encoderCborPool sync.Pool
 	writer          Writer
+	fallback        Writer         // nil if not FallbackWriter
+	errReporter     *errorReporter // nil if not ErrorHandler
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
 }
 
+// New creates a TLog, it panics with an *OptionError if an option is illegal
 func New(opts ...Option) *TLog {
-	opt := setOptions(opts...)
+	tl, err := NewE(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return tl
+}
 
-	tl := &TLog{
+// NewE is New returning the *OptionError instead of panicking
+func NewE(opts ...Option) (*TLog, error) {
+	opt, err := setOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.format == FormatConsole && opt.consoleColor == consoleColorAuto {
+		opt.consoleColor = consoleColorOff
+		if len(os.Getenv("NO_COLOR")) == 0 && isTerminal(os.Stdout) {
+			opt.consoleColor = consoleColorOn
+		}
+	}
+
+	core := &tlogCore{
 		omitEmpty:      opt.omitEmpty,
 		format:         opt.format,
-		level:          opt.level,
 		writer:         opt.writer,
+		fallback:       opt.fallback,
 		timeFormat:     opt.timeFormat,
+		timeLayout:     opt.timeLayout,
+		location:       opt.location,
 		anyMarshalFunc: opt.anyMarshalFunc,
+		sampler:        opt.sampler,
+		hooks:          opt.hooks,
+		durUnit:        opt.durUnit,
+		durInteger:     opt.durInteger,
+		ctxExtractors:  opt.ctxExtractors,
+		maxFieldBytes:  opt.maxFieldBytes,
+		maxLineBytes:   opt.maxLineBytes,
+		done:           make(chan struct{}),
+
+		consoleColor:     opt.consoleColor == consoleColorOn,
+		consoleMultiLine: opt.consoleMultiLine,
 		encoderTextPool: sync.Pool{
 			New: func() any {
 				return &encoderText{
@@ -58,44 +144,155 @@ func New(opts ...Option) *TLog {
 				}
 			},
 		},
+		encoderCborPool: sync.Pool{
+			New: func() any {
+				return &encoderCbor{
+					encoder: encoder{
+						buf: make([]byte, 0, 512),
+					},
+				}
+			},
+		},
 	}
+	tl := &TLog{tlogCore: core}
+	core.loggerKey = opt.loggerKey
+	core.levels = &levelState{
+		base:      opt.level,
+		overrides: opt.levelOverrides,
+		loggers:   map[string]*TLog{"": tl},
+	}
+	tl.level.Store(int64(core.levels.resolve("")))
 
-	return tl
+	if opt.errorHandler != nil {
+		tl.errReporter = &errorReporter{f: opt.errorHandler, interval: opt.errorInterval}
+	}
+	if opt.coarseClock > 0 {
+		tl.clock = newCoarseClock(opt.coarseClock)
+	}
+	tl.initHeaders(opt)
+	if len(opt.redactRules) > 0 {
+		tl.redact = newRedactor(opt.redactRules)
+	}
+	if opt.rateLimit > 0 {
+		tl.limiter = newRateLimiter(opt.rateLimit, opt.rateBurst, opt.rateField)
+		if len(opt.rateField) > 0 {
+			tl.trackKeys = append(tl.trackKeys, opt.rateField)
+		}
+	}
+	if opt.dedupWindow > 0 {
+		tl.dedup = newDeduper(opt.dedupWindow, opt.dedupKeys)
+		tl.trackKeys = append(tl.trackKeys, opt.dedupKeys...)
+		go tl.dedupSweep()
+	}
+	if opt.sampler != nil && opt.sampleReport > 0 {
+		go tl.sampleReport(opt.sampleReport)
+	}
+	return tl, nil
+}
+
+// Close stops the background goroutines started by the options and writes
+// the pending summary lines
+func (tl *TLog) Close() {
+	tl.closeOnce.Do(func() {
+		close(tl.done)
+		if tl.dedup != nil {
+			tl.dedup.sweep(math.MaxInt64) // report all open windows
+		}
+		if tl.errReporter != nil {
+			tl.errReporter.flush()
+		}
+		if tl.clock != nil {
+			tl.clock.stop()
+		}
+	})
+}
+
+// Time of the log line, also used by the file writers for rollover
+func (tl *TLog) timeNow() time.Time {
+	var now time.Time
+	if tl.clock != nil {
+		now = tl.clock.now()
+	} else {
+		now = time.Now()
+	}
+	if tl.location != nil {
+		return now.In(tl.location)
+	}
+	return now
 }
 
 func (tl *TLog) newEncoder(lvl int, doneCallback func(s string)) Encoder {
-	if tl.level&lvl == 0 {
+	if int(tl.level.Load())&lvl == 0 {
 		if doneCallback != nil {
 			doneCallback("(level diabled)")
 		}
-		return nil
+		return tl.nilEncoder()
 	}
+	if tl.sampler != nil && lvl&(FatalLevel|PanicLevel) == 0 && !tl.sampler.Sample(lvl) {
+		tl.sampled[levelIndex(lvl)].Add(1)
+		tl.stats.sampled.Add(1)
+		if doneCallback != nil {
+			doneCallback("(sampled)")
+		}
+		return tl.nilEncoder()
+	}
+	return tl.encoder(lvl, doneCallback)
+}
+
+// A nil encoder of the format, all of its methods are no-ops
+func (tl *TLog) nilEncoder() Encoder {
+	switch tl.format {
+	case FormatJson:
+		return (*encoderJson)(nil)
+	case FormatCBOR:
+		return (*encoderCbor)(nil)
+	}
+	return (*encoderText)(nil)
+}
+
+func (tl *TLog) encoder(lvl int, doneCallback func(s string)) Encoder {
 	var e Encoder
 	if tl.format == FormatJson {
 		obj := tl.encoderJsonPool.Get().(*encoderJson)
-        obj.tl = tl
-		obj.level = lvl
-		obj.omitEmpty = tl.omitEmpty
-		obj.timeFormat = tl.timeFormat
-		obj.writer = tl.writer
-		obj.doneCallback = doneCallback
-		obj.anyMarshalFunc = tl.anyMarshalFunc
+		obj.reset(tl, lvl, doneCallback)
 		obj.init()
 		e = obj
-	} else if tl.format == FormatText {
+	} else if tl.format == FormatCBOR {
+		obj := tl.encoderCborPool.Get().(*encoderCbor)
+		obj.reset(tl, lvl, doneCallback)
+		obj.init()
+		e = obj
+	} else {
 		obj := tl.encoderTextPool.Get().(*encoderText)
-        obj.tl = tl
-		obj.level = lvl
-		obj.omitEmpty = tl.omitEmpty
-		obj.timeFormat = tl.timeFormat
-		obj.writer = tl.writer
-		obj.doneCallback = doneCallback
-		obj.anyMarshalFunc = tl.anyMarshalFunc
+		obj.reset(tl, lvl, doneCallback)
+		obj.logfmt = tl.format == FormatLogfmt
+		obj.console = tl.format == FormatConsole
 		obj.init()
 		e = obj
 	}
+	if len(tl.name) != 0 {
+		e.Str(tl.loggerKey, tl.name)
+	}
 	return e
 }
+
+// WithLevel starts a line of any level, the custom ones included, lvl must be
+// a single level, a mask of several ones gets a no-op encoder
+func (tl *TLog) WithLevel(lvl int) Encoder {
+	if lvl <= 0 || lvl&(lvl-1) != 0 {
+		return tl.nilEncoder()
+	}
+	switch lvl {
+	case FatalLevel:
+		return tl.Fatal()
+	case PanicLevel:
+		return tl.Panic()
+	}
+	return tl.newEncoder(lvl, nil)
+}
+func (tl *TLog) Trace() Encoder {
+	return tl.newEncoder(TraceLevel, nil)
+}
 func (tl *TLog) Debug() Encoder {
 	return tl.newEncoder(DebugLevel, nil)
 }
@@ -1,10 +1,26 @@
 package tlog
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestTLog(t *testing.T) {
@@ -13,8 +29,8 @@ func TestTLog(t *testing.T) {
 	//writer := NewWriteToFileMixed(FileStoreMode(AppendOneFile))
 	tl := New(OmitEmpty(true), TimeFormat(HumanReadableTimeMs), SetWriter(writer), Format(FormatJson))
 	s1 := `i'm sorry, "cuisw" is right! ohh.\n`
-	tl.Debug().Fmt("fmt", "n=%d type=%s v=%v %s", 10, reflect.TypeOf(*tl).String(), *tl, s1).Msg("")
-	tl.Debug().Fmt("> ", "n=%d type=%s v=%v %s", 10, reflect.TypeOf(*tl).String(), *tl, s1).Msg("")
+	tl.Debug().Fmt("fmt", "n=%d type=%s v=%v %s", 10, reflect.TypeOf(tl).String(), tl, s1).Msg("")
+	tl.Debug().Fmt("> ", "n=%d type=%s v=%v %s", 10, reflect.TypeOf(tl).String(), tl, s1).Msg("")
 
 	tl.Debug().Str(s1, "val").Msg("")
 	tl.Debug().FastStr("str", "val").Msg("")
@@ -75,3 +91,1355 @@ func TestTLog(t *testing.T) {
 	}
 	tl.Info().AnyMarshalFunc(f1).Any("anybody2", &js{Name: "anybody", Empty: "", Age: 21}).Go()
 }
+
+func TestWriteToSplunkHEC(t *testing.T) {
+	var mtx sync.Mutex
+	var events []map[string]any
+	ackId := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk abc" || r.Header.Get("X-Splunk-Request-Channel") != "ch-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.URL.Path == "/services/collector/ack" {
+			var req struct {
+				Acks []int `json:"acks"`
+			}
+			json.Unmarshal(body, &req)
+			resp := map[string]map[string]bool{"acks": {}}
+			for _, id := range req.Acks {
+				resp["acks"][strconv.Itoa(id)] = true
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		for dec.More() {
+			var ev map[string]any
+			if err := dec.Decode(&ev); err != nil {
+				t.Errorf("invalid hec payload: %s", err)
+				return
+			}
+			events = append(events, ev)
+		}
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, ackId)
+		ackId++
+	}))
+	defer srv.Close()
+
+	w := NewWriteToSplunkHEC(PostUrl(srv.URL+"/services/collector/event"), HECToken("abc"),
+		HECHost("h1"), HECSource("app"), HECChannel("ch-1"), HECBatchSize(2),
+		HECFlushInterval(10*time.Millisecond))
+	defer w.Close()
+	tl := New(SetWriter(w))
+	tl.Info().Int("n", 1).Msg("one")
+	tl.Info().Int("n", 2).Msg("two")
+	tl.Info().Int("n", 3).Msg("three")
+	w.Flush()
+	for i := 0; i < 100 && w.PendingAcks() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.PendingAcks() != 0 {
+		t.Errorf("batches were not acknowledged")
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	ev := events[0]
+	if ev["host"] != "h1" || ev["source"] != "app" || ev["sourcetype"] != "_json" {
+		t.Errorf("bad metadata: %v", ev)
+	}
+	if _, ok := ev["time"].(float64); !ok {
+		t.Errorf("bad time: %v", ev["time"])
+	}
+	if inner, _ := ev["event"].(map[string]any); inner["msg"] != "one" {
+		t.Errorf("bad event: %v", ev["event"])
+	}
+}
+
+func TestWriteToSplunkHECRetry(t *testing.T) {
+	var mtx sync.Mutex
+	sends := map[string]int{} // by event, the failed posts too
+	posts, ackId := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.URL.Path == "/services/collector/ack" {
+			w.WriteHeader(http.StatusServiceUnavailable) // never acknowledged
+			return
+		}
+		var ev struct{ Event string }
+		for dec := json.NewDecoder(bytes.NewReader(body)); dec.Decode(&ev) == nil; {
+			if strings.HasSuffix(ev.Event, " info msg=one\\n") {
+				ev.Event = "one"
+			}
+			sends[ev.Event]++
+		}
+		if posts++; posts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, ackId)
+		ackId++
+	}))
+	defer srv.Close()
+
+	w := NewWriteToSplunkHEC(PostUrl(srv.URL+"/services/collector/event"), HECToken("abc"),
+		HECChannel("ch-1"), HECBatchSize(1), HECFlushInterval(5*time.Millisecond),
+		HECAckTimeout(20*time.Millisecond))
+	tl := New(SetWriter(w), Format(FormatText))
+	tl.Info().Msg("one\n")
+	e := tl.Info()
+	if n, err := w.Write(e, []byte("two\n")); n != 4 || err != nil {
+		t.Errorf("Write: %d %v", n, err)
+	}
+	e.Discard().Go()
+
+	// the failed batch is sent again, then both are resent as they're never
+	// acknowledged, until they are dropped: nothing pending nor queued for a
+	// few flushes in a row
+	idle := 0
+	for deadline := time.Now().Add(3 * time.Second); idle < 3; time.Sleep(5 * time.Millisecond) {
+		w.ackMtx.Lock()
+		if len(w.pending) == 0 && len(w.retry) == 0 {
+			idle++
+		} else {
+			idle = 0
+		}
+		w.ackMtx.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("%d pending acks, batches still queued", w.PendingAcks())
+		}
+	}
+	w.Close()
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, ev := range []string{"one", "two"} {
+		if n := sends[ev]; n < 2 || n > 1+hecMaxRetries {
+			t.Errorf("%s sent %d times, want 2..%d: %v", ev, n, 1+hecMaxRetries, sends)
+		}
+	}
+}
+
+type writeToBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *writeToBuffer) Write(e Encoder, p []byte) (n int, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.buf.Write(p)
+}
+func (w *writeToBuffer) String() string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.buf.String()
+}
+
+// parseLogfmt is a strict logfmt parser, quoted values use JSON escapes
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	kv := map[string]string{}
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(line) && line[j] != '=' && line[j] != ' ' && line[j] != '"' {
+			j++
+		}
+		if j == i || j == len(line) || line[j] != '=' {
+			t.Fatalf("bad key at %d: %q", i, line)
+		}
+		k := line[i:j]
+		i = j + 1
+		if i < len(line) && line[i] == '"' {
+			j = i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j == len(line) {
+				t.Fatalf("unterminated value: %q", line)
+			}
+			var v string
+			if err := json.Unmarshal([]byte(line[i:j+1]), &v); err != nil {
+				t.Fatalf("bad quoted value %s: %s", line[i:j+1], err)
+			}
+			kv[k] = v
+			i = j + 1
+		} else {
+			j = i
+			for j < len(line) && line[j] != ' ' {
+				if line[j] == '=' || line[j] == '"' {
+					t.Fatalf("unquoted value contains %q: %q", line[j], line)
+				}
+				j++
+			}
+			kv[k] = line[i:j]
+			i = j
+		}
+	}
+	return kv
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatLogfmt), TimeFormat(HumanReadableTimeMs))
+	s1 := "a=b \"quoted\"\n\ttab\\"
+	tl.Info().Str("str", s1).Str("key with space=", "v").Int("n", 3).
+		Strs("strs", []string{"x", "y z"}).Fmt("fmt", "%d %s", 1, "two").
+		Str("bad", "\xff").Msg("hello world")
+
+	line := strings.TrimSuffix(w.String(), "\n")
+	kv := parseLogfmt(t, line)
+	want := map[string]string{
+		"level":           "info",
+		"str":             s1,
+		"key_with_space_": "v",
+		"n":               "3",
+		"strs":            `["x","y z"]`,
+		"fmt":             "1 two",
+		"bad":             "�",
+		"msg":             "hello world",
+	}
+	for k, v := range want {
+		if kv[k] != v {
+			t.Errorf("%s: got %q, want %q (%s)", k, kv[k], v, line)
+		}
+	}
+	if len(kv["time"]) != len("2023-07-14 21:08:20.212") {
+		t.Errorf("bad time: %q", kv["time"])
+	}
+}
+
+func TestFormatConsole(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatConsole), ConsoleColor(false), ConsoleMultiLine(true))
+	tl.Warn().Str("error", "refused").Str("stack", "a.go:1\nb.go:2").Msg("dial")
+	lines := strings.Split(w.String(), "\n")
+	if !strings.HasSuffix(lines[0], " WARN  dial error=refused") {
+		t.Errorf("bad line: %q", lines[0])
+	}
+	if len(lines) != 5 || lines[1] != "  stack:" || lines[2] != "    a.go:1" || lines[3] != "    b.go:2" {
+		t.Errorf("bad stack rendering: %q", lines)
+	}
+
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatConsole), ConsoleColor(true))
+	tl.Error().Str("err", "x").Msg("")
+	if !strings.Contains(w.String(), colorRed+"err=x"+colorReset) {
+		t.Errorf("error field is not highlighted: %q", w.String())
+	}
+
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatConsole), ConsoleColor(false))
+	tl.Info().Str("user", "tom").Msg("bye\n2023-07-14 21:08:20.212 INFO  forged")
+	if got := w.String(); strings.Count(got, "\n") != 1 || !strings.HasSuffix(got, ` INFO  bye\n2023-07-14 21:08:20.212 INFO  forged user=tom`+"\n") {
+		t.Errorf("msg not escaped: %q", got)
+	}
+}
+
+func TestFormatCBOR(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatCBOR))
+	tl.Info().Str("str", "a\"b").Int("neg", -300).Uint64("big", 1<<40).
+		Floats64("fs", []float64{1.5, -2}).Bools("bs", []bool{true, false}).
+		Ints("nil", nil).RawJSON("raw", []byte(`{"a":1}`)).
+		Any("any", []string{"x"}).Time("t", time.Unix(0, 0).UTC(), time.RFC3339).Msg("hello")
+	tl.Warn().Msg("second")
+
+	var out bytes.Buffer
+	if err := CBORToJSON(bytes.NewReader(w.buf.Bytes()), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2: %s", len(lines), out.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("invalid json %s: %s", lines[0], err)
+	}
+	want := `{"any":["x"],"big":1099511627776,"bs":[true,false],"fs":[1.5,-2],"level":"info",` +
+		`"msg":"hello","neg":-300,"raw":{"a":1},"str":"a\"b","t":"1970-01-01T00:00:00Z"}`
+	tm := rec["time"]
+	delete(rec, "time")
+	if got, _ := json.Marshal(rec); string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, tm.(string)); err != nil {
+		t.Errorf("bad time %v: %s", tm, err)
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	w := &writeToBuffer{}
+	opts := []Option{SetWriter(w), TimeFieldName("@timestamp"), LevelFieldName("severity"),
+		MessageFieldName("message"), LevelValue(WarnLevel, "WARNING"), TimeFormat(UnixTimestamp)}
+	tl := New(opts...)
+	tl.Warn().Msg("disk full")
+	var rec map[string]any
+	if err := json.Unmarshal(w.buf.Bytes(), &rec); err != nil {
+		t.Fatalf("invalid json %q: %s", w.String(), err)
+	}
+	if rec["severity"] != "WARNING" || rec["message"] != "disk full" || rec["@timestamp"] == nil || len(rec) != 3 {
+		t.Errorf("bad record: %s", w.String())
+	}
+
+	w.buf.Reset()
+	tl = New(append(opts, Format(FormatLogfmt))...)
+	tl.Warn().Msgf("disk %s", "full")
+	kv := parseLogfmt(t, strings.TrimSuffix(w.String(), "\n"))
+	if kv["severity"] != "WARNING" || kv["message"] != "disk full" || kv["@timestamp"] == "" {
+		t.Errorf("bad record: %s", w.String())
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	w := &writeToBuffer{}
+	check := func(layout string, opts ...Option) {
+		t.Helper()
+		w.buf.Reset()
+		New(append(opts, SetWriter(w))...).Info().Go()
+		var rec map[string]any
+		if err := json.Unmarshal(w.buf.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid json %q: %s", w.String(), err)
+		}
+		s, _ := rec["time"].(string)
+		if _, err := time.Parse(layout, s); err != nil {
+			t.Errorf("bad time %q: %s", s, err)
+		}
+	}
+	check(time.RFC3339, TimeFormat(RFC3339Time), UTC())
+	check(time.RFC3339Nano, TimeFormat(RFC3339NanoTime))
+	check("2006-01-02 15:04:05.000", TimeFormat(HumanReadableTimeMs))
+	check("Jan _2 15:04:05.000", TimeLayout("Jan _2 15:04:05.000"))
+
+	w.buf.Reset()
+	New(SetWriter(w), TimeFormat(RFC3339Time), UTC()).Info().Go()
+	if !strings.Contains(w.String(), `Z"`) {
+		t.Errorf("time is not in UTC: %s", w.String())
+	}
+	w.buf.Reset()
+	New(SetWriter(w), TimeFormat(UnixTimestampNs), Format(FormatText)).Info().Go()
+	if ns, _ := strconv.ParseInt(strings.Fields(w.String())[0], 10, 64); time.Since(time.Unix(0, ns)) > time.Minute {
+		t.Errorf("bad unix nano time: %s", w.String())
+	}
+}
+
+type writeToDiscard struct{}
+
+func (w writeToDiscard) Write(e Encoder, p []byte) (n int, err error) { return len(p), nil }
+
+func TestCoarseClock(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), CoarseClock(time.Millisecond), Format(FormatText))
+	defer tl.Close()
+	tl.Info().Go()
+	time.Sleep(5 * time.Millisecond)
+	tl.Info().Go()
+	for _, line := range strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n") {
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05.000", line[:23], time.Local)
+		if err != nil || time.Since(ts) > time.Second {
+			t.Errorf("bad time %q: %v", line, err)
+		}
+	}
+}
+
+func benchmarkHeader(b *testing.B, opts ...Option) {
+	tl := New(append(opts, SetWriter(writeToDiscard{}))...)
+	defer tl.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tl.Info().Go()
+		}
+	})
+}
+
+func BenchmarkHeaderTime(b *testing.B) {
+	benchmarkHeader(b)
+}
+
+func BenchmarkHeaderTimeCoarse(b *testing.B) {
+	benchmarkHeader(b, CoarseClock(time.Millisecond))
+}
+
+func TestSampler(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatText), SetSampler(&LevelSampler{
+		DebugSampler: &BasicSampler{N: 3},
+		InfoSampler:  &BurstSampler{Burst: 2, Period: time.Hour},
+	}))
+	for i := 0; i < 9; i++ {
+		tl.Debug().Int("i", i).Str("s", "x").Msg("debug")
+		tl.Info().Int("i", i).Type("t", i).Msg("info")
+		tl.Warn().Msg("warn")
+	}
+	if n := strings.Count(w.String(), " debug "); n != 3 {
+		t.Errorf("got %d debug lines, want 3", n)
+	}
+	if n := strings.Count(w.String(), " info "); n != 2 {
+		t.Errorf("got %d info lines, want 2", n)
+	}
+	if n := strings.Count(w.String(), " warn "); n != 9 {
+		t.Errorf("got %d warn lines, want 9", n)
+	}
+	if s := tl.Stats(); s.Sampled != 13 {
+		t.Errorf("got %d sampled, want 13", s.Sampled)
+	}
+
+	w.buf.Reset()
+	tl.writeSampleReport()
+	if !strings.Contains(w.String(), "sampled_debug=6 sampled_info=7 msg=sampler dropped lines") {
+		t.Errorf("bad report: %s", w.String())
+	}
+	tl.SetLevel(WarnLevel)
+	tl.Debug().Int("i", 1).Msg("disabled level")
+
+	// fatal and panic lines are never sampled
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatText), SetSampler(&BasicSampler{N: 1000}))
+	for i := 0; i < 3; i++ {
+		func() {
+			defer func() { recover() }()
+			tl.Panic().Msg("boom")
+		}()
+	}
+	if n := strings.Count(w.String(), " panic "); n != 3 {
+		t.Errorf("got %d panic lines, want 3", n)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatText), Dedup(time.Hour, "host"))
+	for i := 0; i < 5; i++ {
+		tl.Error().Str("host", "a").Int("i", i).Msg("connection refused")
+	}
+	tl.Error().Str("host", "b").Msg("connection refused")
+	tl.Warn().Str("host", "a").Msgf("connection %s", "refused")
+	tl.Close()
+
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4: %s", len(lines), w.String())
+	}
+	if !strings.HasSuffix(lines[0], " error host=a i=0 msg=connection refused") {
+		t.Errorf("bad first line: %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[3], " error host=a repeated=4 msg=connection refused") {
+		t.Errorf("bad summary line: %s", lines[3])
+	}
+
+	// the summaries of the keys can be told apart
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatJson), Dedup(time.Hour, "host"))
+	for i := 0; i < 3; i++ {
+		tl.Error().Str("host", "a").Msg("refused")
+		tl.Error().Str("host", "b").Msg("refused")
+	}
+	tl.Close()
+	for _, want := range []string{`"host":"a","repeated":2,"msg":"refused"}`, `"host":"b","repeated":2,"msg":"refused"}`} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("no summary %s: %s", want, w.String())
+		}
+	}
+
+	// the lines without msg are all written
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatJson), Dedup(time.Hour))
+	tl.Info().Int("n", 1).Go()
+	tl.Info().Int("n", 2).Go()
+	tl.Close()
+	if !strings.Contains(w.String(), `"n":1}`) || !strings.Contains(w.String(), `"n":2}`) {
+		t.Errorf("got %s", w.String())
+	}
+
+	// the loggers are deduplicated apart
+	w.buf.Reset()
+	tl = New(SetWriter(w), Format(FormatJson), Dedup(time.Hour))
+	for i := 0; i < 2; i++ {
+		tl.Named("db").Error().Msg("connection refused")
+		tl.Named("cache").Error().Msg("connection refused")
+	}
+	tl.Close()
+	for _, want := range []string{`"logger":"db","msg":"connection refused"}`, `"logger":"cache","msg":"connection refused"}`,
+		`"logger":"db","repeated":1,`, `"logger":"cache","repeated":1,`} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("no line %s: %s", want, w.String())
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatText), RateLimit(0.001, 2), RateLimitField("client_ip"))
+	for i := 0; i < 5; i++ {
+		tl.Info().Str("client_ip", "10.0.0.1").Msg("req")
+		tl.Info().Str("client_ip", "10.0.0.2").Msg("req")
+		tl.Info().RateKey("db.go:12").Msg("slow query")
+		tl.Info().Msg("no key")
+	}
+	out := w.String()
+	for s, want := range map[string]int{"10.0.0.1": 2, "10.0.0.2": 2, "slow query": 2, "no key": 5} {
+		if n := strings.Count(out, s); n != want {
+			t.Errorf("%s: got %d lines, want %d", s, n, want)
+		}
+	}
+	if n := tl.Stats().RateLimited; n != 9 {
+		t.Errorf("got %d rate limited, want 9", n)
+	}
+
+	// flooding new keys doesn't refill the bucket of a key
+	for i := 0; i < rateLimitMaxKeys+10; i++ {
+		tl.Info().Str("client_ip", "flood-"+strconv.Itoa(i)).Msg("req")
+	}
+	w.buf.Reset()
+	tl.Info().Str("client_ip", "10.0.0.1").Msg("req")
+	if w.buf.Len() != 0 {
+		t.Errorf("bucket was reset: %s", w.String())
+	}
+	if n := len(tl.limiter.buckets); n != rateLimitMaxKeys {
+		t.Errorf("got %d buckets, want %d", n, rateLimitMaxKeys)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	rules := []RedactRule{
+		{Keys: []string{"password", "Authorization"}},
+		{KeyGlobs: []string{"*_token"}},
+		{Keys: []string{"card"}, ValueRegexp: regexp.MustCompile(`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{4}\b`), Mask: MaskKeepLast(4)},
+		{ValueRegexp: regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`)},
+	}
+	secrets := []string{"hunter2", "Bearer abc", "tok-123", "4111111111111111", "4111 1111 1111 1112", "tom@example.com", "8765"}
+	type login struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	for _, format := range []int{FormatJson, FormatText, FormatLogfmt, FormatConsole, FormatCBOR} {
+		w := &writeToBuffer{}
+		tl := New(SetWriter(w), Format(format), Redact(rules...))
+		tl.Info().Str("password", "hunter2").
+			Str("authorization", "Bearer abc").
+			FastStr("refresh_token", "tok-123").
+			Int64("card", 4111111111111111).
+			Fmt("note", "paid with %s by %s", "4111 1111 1111 1112", "tom@example.com").
+			Strs("emails", []string{"ok", "tom@example.com"}).
+			Any("login", login{User: "tom", Password: "hunter2", Email: "tom@example.com"}).
+			RawJSON("req", []byte(`{"headers":{"Authorization":"Bearer abc"},"retries":3,"cards":["4111111111111111"],"password":8765}`)).
+			Time("api_token", time.Now(), time.RFC3339).
+			Str("user", "tom").
+			Msgf("login of %s", "tom@example.com")
+
+		out := w.String()
+		if format == FormatCBOR {
+			var js bytes.Buffer
+			if err := CBORToJSON(strings.NewReader(out), &js); err != nil {
+				t.Fatalf("cbor: %s", err)
+			}
+			out = js.String()
+		}
+		for _, s := range secrets {
+			if strings.Contains(out, s) {
+				t.Errorf("format %d: %q leaked: %s", format, s, out)
+			}
+		}
+		for _, s := range []string{"[REDACTED]", "************1111", "tom"} {
+			if !strings.Contains(out, s) {
+				t.Errorf("format %d: %q not found: %s", format, s, out)
+			}
+		}
+		if format == FormatJson && !json.Valid([]byte(out)) {
+			t.Errorf("invalid json: %s", out)
+		}
+	}
+	if got := MaskKeepLast(4)("4111 1111"); got != "*****1111" {
+		t.Errorf("MaskKeepLast: %s", got)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	big := strings.Repeat("日本語", 100) // 900 bytes
+	type blob struct {
+		Data []int `json:"data"`
+	}
+	for _, format := range []int{FormatJson, FormatLogfmt, FormatCBOR} {
+		w := &writeToBuffer{}
+		tl := New(SetWriter(w), Format(format), MaxFieldBytes(100), MaxLineBytes(1024))
+		tl.Info().Str("s", big).Any("blob", blob{Data: make([]int, 500)}).Str("small", "ok").Msg("hi")
+		out := w.String()
+		if format == FormatCBOR {
+			var js bytes.Buffer
+			if err := CBORToJSON(strings.NewReader(out), &js); err != nil {
+				t.Fatalf("cbor: %s", err)
+			}
+			out = js.String()
+		}
+		if len(out) > 1024 {
+			t.Errorf("format %d: line of %d bytes", format, len(out))
+		}
+		if !strings.Contains(out, "…(truncated 825 bytes)") || !strings.Contains(out, "truncated=true") && !strings.Contains(out, `"truncated":true`) {
+			t.Errorf("format %d: no truncation marker: %s", format, out)
+		}
+		if !utf8.ValidString(out) || !strings.Contains(out, "small") {
+			t.Errorf("format %d: %s", format, out)
+		}
+		if format != FormatLogfmt {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(out), &m); err != nil {
+				t.Errorf("format %d: invalid json %s: %s", format, err, out)
+			}
+		}
+	}
+
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), MaxLineBytes(200))
+	tl.Info().Str("a", strings.Repeat("x", 150)).Str("b", strings.Repeat("y", 150)).Msg("hi")
+	if out := w.String(); len(out) > 300 || !strings.Contains(out, `"truncated":true`) {
+		t.Errorf("line limit: %d bytes: %s", len(out), out)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var errors atomic.Int64
+	counter := HookFunc(func(e Encoder, level int, msg string) {
+		if level == ErrorLevel {
+			errors.Add(1)
+		}
+	})
+	traceID := HookFunc(func(e Encoder, level int, msg string) {
+		e.Str("trace_id", "abc123")
+	})
+	veto := HookFunc(func(e Encoder, level int, msg string) {
+		if msg == "health check" {
+			e.Discard()
+		}
+	})
+	for _, format := range []int{FormatJson, FormatLogfmt, FormatCBOR} {
+		errors.Store(0)
+		w := &writeToBuffer{}
+		tl := New(SetWriter(w), Format(format), Hooks(counter, traceID), Hooks(veto))
+		tl.Info().Msg("health check")
+		tl.Error().Str("user", "tom").Msg("failed")
+		tl.Error().Str("user", "tom").Go()
+		tl.Info().Discard().Msg("dropped")
+		out := w.String()
+		if format == FormatCBOR {
+			var js bytes.Buffer
+			if err := CBORToJSON(strings.NewReader(out), &js); err != nil {
+				t.Fatalf("cbor: %s", err)
+			}
+			out = js.String()
+		}
+		if strings.Contains(out, "health check") || strings.Contains(out, "dropped") {
+			t.Errorf("format %d: vetoed line written: %s", format, out)
+		}
+		if n := strings.Count(out, "abc123"); n != 2 {
+			t.Errorf("format %d: got %d trace_id fields: %s", format, n, out)
+		}
+		if n := errors.Load(); n != 2 {
+			t.Errorf("format %d: got %d errors", format, n)
+		}
+	}
+
+	// a hook may keep the msg of Msgf
+	var msgs []string
+	keep := HookFunc(func(e Encoder, level int, msg string) { msgs = append(msgs, msg) })
+	for _, format := range []int{FormatJson, FormatText, FormatCBOR} {
+		msgs = msgs[:0]
+		tl := New(SetWriter(writeToDiscard{}), Format(format), Hooks(keep))
+		tl.Info().Msgf("first %d", 1)
+		tl.Info().Msgf("SECOND %d", 2)
+		if len(msgs) != 2 || msgs[0] != "first 1" {
+			t.Errorf("format %d: got %q", format, msgs)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	type requestIDKey struct{}
+	type userIDKey struct{}
+
+	if FromContext(context.Background()) == nil {
+		t.Fatal("no default logger")
+	}
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), ContextExtractors(
+		ExtractValue(requestIDKey{}, "request_id"),
+		ExtractValue(userIDKey{}, "user_id"),
+		ExtractDeadline("deadline_ms"),
+	))
+	ctx := WithContext(context.Background(), tl)
+	ctx = context.WithValue(ctx, requestIDKey{}, "req-1")
+	ctx = context.WithValue(ctx, userIDKey{}, 42)
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if FromContext(ctx) != tl {
+		t.Fatal("FromContext: not the logger of the context")
+	}
+	FromContext(ctx).Info().Ctx(ctx).Msg("hello")
+	FromContext(ctx).Info().Ctx(context.Background()).Msg("no fields")
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["request_id"] != "req-1" || m["user_id"] != float64(42) {
+		t.Errorf("got %s", lines[0])
+	}
+	if d, ok := m["deadline_ms"].(float64); !ok || d <= 0 || d > 60000 {
+		t.Errorf("deadline_ms: got %s", lines[0])
+	}
+	if strings.Contains(lines[1], "request_id") || strings.Contains(lines[1], "deadline_ms") {
+		t.Errorf("got %s", lines[1])
+	}
+}
+
+func TestTrace(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	if err != nil || sc.TraceFlags != 1 || sc.SpanID[7] != 0xb7 {
+		t.Fatalf("ParseTraceparent: %v %v", sc, err)
+	}
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("ParseTraceparent(%q): no error", s)
+		}
+	}
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x"); err != nil {
+		t.Errorf("future version: %s", err)
+	}
+
+	ctx := ContextWithSpanContext(context.Background(), sc)
+	for _, format := range []int{FormatJson, FormatLogfmt, FormatCBOR} {
+		w := &writeToBuffer{}
+		tl := New(SetWriter(w), Format(format), ContextExtractors(ExtractTrace(nil)))
+		tl.Info().Ctx(ctx).Msg("in span")
+		tl.Info().Ctx(context.Background()).Msg("no span")
+		out := w.String()
+		if format == FormatCBOR {
+			var js bytes.Buffer
+			if err := CBORToJSON(strings.NewReader(out), &js); err != nil {
+				t.Fatalf("cbor: %s", err)
+			}
+			out = js.String()
+		}
+		for _, s := range []string{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "trace_flags"} {
+			if strings.Count(out, s) != 1 {
+				t.Errorf("format %d: %s: %s", format, s, out)
+			}
+		}
+	}
+
+	tl := New(SetWriter(writeToDiscard{}), ContextExtractors(ExtractTrace(nil)))
+	allocs := testing.AllocsPerRun(100, func() {
+		tl.Info().Ctx(ctx).Msg("in span")
+	})
+	if allocs != 0 && !raceEnabled {
+		t.Errorf("got %v allocs", allocs)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	var calls int
+	summary := func() string {
+		calls++
+		return "cache: 42 entries"
+	}
+	veto := HookFunc(func(e Encoder, level int, msg string) {
+		if msg == "vetoed" {
+			e.Discard()
+		}
+	})
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatLogfmt), Hooks(veto), RateLimit(0.001, 1), RateLimitField("ip"))
+	tl.Info().LazyStr("cache", summary).Func(func(e Encoder) {
+		e.Int("size", 42).Str("hit", summary())
+	}).Msg("written")
+	tl.Info().LazyStr("cache", summary).Msg("vetoed")
+	tl.Info().Str("ip", "10.0.0.1").LazyStr("cache", summary).Msg("first")
+	tl.Info().Str("ip", "10.0.0.1").LazyStr("cache", summary).Msg("rate limited")
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+	out := w.String()
+	if !strings.Contains(out, `msg=written cache="cache: 42 entries" size=42 hit="cache: 42 entries"`) {
+		t.Errorf("got %s", out)
+	}
+
+	// nor for the repeats suppressed by Dedup
+	calls = 0
+	tl = New(SetWriter(w), Dedup(time.Hour))
+	for i := 0; i < 3; i++ {
+		tl.Info().LazyStr("cache", summary).Msg("repeated")
+	}
+	tl.Close()
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+
+	calls = 3
+	tl = New(SetWriter(w))
+	tl.SetLevel(InfoLevel | ErrorLevel)
+	if tl.Enabled(DebugLevel) || !tl.Enabled(InfoLevel) {
+		t.Error("Enabled")
+	}
+	tl.Debug().LazyStr("cache", summary).Msg("disabled")
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func TestFmtAllocs(t *testing.T) {
+	for _, format := range []int{FormatJson, FormatText, FormatLogfmt, FormatConsole, FormatCBOR} {
+		w := &writeToBuffer{}
+		tl := New(SetWriter(w), Format(format))
+		tl.Info().Fmt("q", `say "%s"`, "hi\n").Fmt("empty", "%s", "").Fmt("utf8", "%s", "日本").Msgf("user %s id=%d", "tom", 42)
+		out := w.String()
+		if format == FormatCBOR {
+			var js bytes.Buffer
+			if err := CBORToJSON(strings.NewReader(out), &js); err != nil {
+				t.Fatalf("cbor: %s", err)
+			}
+			out = js.String()
+		}
+		if strings.Contains(out, "empty") || !strings.Contains(out, "user tom id=42") || !strings.Contains(out, "日本") {
+			t.Errorf("format %d: %s", format, out)
+		}
+		if format == FormatJson || format == FormatCBOR {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(out), &m); err != nil || m["q"] != "say \"hi\n\"" {
+				t.Errorf("format %d: %v %s", format, err, out)
+			}
+		}
+
+		// The []any of a call through the Encoder interface escapes, that's
+		// the caller's allocation
+		tl = New(SetWriter(writeToDiscard{}), Format(format))
+		fmtArgs, msgArgs := []any{"hi", 7}, []any{"tom", 42}
+		allocs := testing.AllocsPerRun(100, func() {
+			tl.Info().Fmt("q", `say "%s" %d`, fmtArgs...).Msgf("user %s id=%d", msgArgs...)
+		})
+		if allocs != 0 && !raceEnabled {
+			t.Errorf("format %d: got %v allocs", format, allocs)
+		}
+	}
+}
+
+func BenchmarkMsgf(b *testing.B) {
+	tl := New(SetWriter(writeToDiscard{}))
+	fmtArgs, msgArgs := []any{"hi"}, []any{"tom", 42}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tl.Info().Fmt("q", `say "%s"`, fmtArgs...).Msgf("user %s id=%d", msgArgs...)
+	}
+}
+
+type constStringer struct{}
+
+func (constStringer) String() string { return "const" }
+
+func TestTypedFields(t *testing.T) {
+	ip := netip.MustParseAddr("192.168.1.10")
+	ip6 := netip.MustParseAddr("2001:db8::1")
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	mac := net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}
+	ts := time.Date(2023, 7, 14, 21, 8, 20, 212e6, time.UTC)
+	var nilStringer fmt.Stringer
+	steps := []time.Duration{time.Second, 2 * time.Millisecond}
+	body, hash, payload := []byte(`{"a":1}`), []byte{0xde, 0xad, 0xbe, 0xef}, []byte("hello")
+	log := func(tl *TLog) {
+		tl.Info().Dur("took", 1500*time.Microsecond).
+			Durs("steps", steps).
+			Bytes("body", body).
+			Hex("hash", hash).
+			Base64("payload", payload).
+			IPAddr("ip", ip).IPAddr("ip6", ip6).
+			IPPrefix("net", prefix).
+			MACAddr("mac", mac).
+			Stringer("s", constStringer{}).Stringer("nil", nilStringer).
+			Timestamp("at", ts).
+			Msg("typed")
+	}
+
+	w := &writeToBuffer{}
+	log(New(SetWriter(w), TimeZone(time.UTC), TimeFormat(RFC3339MsTime)))
+	var m map[string]any
+	if err := json.Unmarshal([]byte(w.String()), &m); err != nil {
+		t.Fatalf("%s: %s", err, w.String())
+	}
+	want := map[string]any{
+		"took": 1.5, "steps": []any{1000.0, 2.0}, "body": `{"a":1}`, "hash": "deadbeef",
+		"payload": "aGVsbG8=", "ip": "192.168.1.10", "ip6": "2001:db8::1", "net": "10.0.0.0/8",
+		"mac": "00:1a:2b:3c:4d:5e", "s": "const", "nil": nil, "at": "2023-07-14T21:08:20.212Z",
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(m[k], v) {
+			t.Errorf("%s: got %v, want %v", k, m[k], v)
+		}
+	}
+
+	w = &writeToBuffer{}
+	log(New(SetWriter(w), Format(FormatLogfmt), DurationUnit(time.Microsecond), DurationInteger(true), TimeFormat(UnixTimestamp)))
+	kv := parseLogfmt(t, strings.TrimSpace(w.String()))
+	if kv["took"] != "1500" || kv["steps"] != "[1000000,2000]" || kv["body"] != `{"a":1}` || kv["at"] != "1689368900" {
+		t.Errorf("logfmt: %s", w.String())
+	}
+
+	w = &writeToBuffer{}
+	log(New(SetWriter(w), Format(FormatCBOR)))
+	var js bytes.Buffer
+	if err := CBORToJSON(strings.NewReader(w.String()), &js); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"took":1.5`, `"hash":"deadbeef"`, `"payload":"aGVsbG8="`, `"ip6":"2001:db8::1"`, `"net":"10.0.0.0/8"`, `"mac":"00:1a:2b:3c:4d:5e"`, `"nil":null`, `"at":"2023-07-14T21:08:20.212Z"`} {
+		if !strings.Contains(js.String(), s) {
+			t.Errorf("cbor: no %s in %s", s, js.String())
+		}
+	}
+
+	for _, format := range []int{FormatJson, FormatLogfmt, FormatCBOR} {
+		tl := New(SetWriter(writeToDiscard{}), Format(format))
+		allocs := testing.AllocsPerRun(100, func() { log(tl) })
+		if allocs != 0 && !raceEnabled {
+			t.Errorf("format %d: got %v allocs", format, allocs)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), TimeZone(time.UTC), TimeFormat(RFC3339Time))
+	reqFields := func(user string) []Field {
+		return []Field{String("user", user), Int64("id", 7), Bool("admin", true)}
+	}
+	fields := append(reqFields("tom"),
+		Strings("roles", []string{"a", "b"}), Int("n", 1), Uint64("u", 2), Float64("f", 0.5),
+		Dur("took", 2*time.Millisecond), Time("at", time.Date(2023, 7, 14, 21, 8, 20, 0, time.UTC)),
+		Err(io.EOF), NamedErr("nil_err", nil), Bytes("b", []byte("raw")), Stringer("s", constStringer{}),
+		Any("m", map[string]int{"x": 1}))
+	tl.Log(InfoLevel, "login", fields...)
+	tl.Warn().Fields(reqFields("jerry")...).Str("extra", "1").Msg("builder")
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatalf("%s: %s", err, lines[0])
+	}
+	want := map[string]any{
+		"level": "info", "msg": "login", "user": "tom", "id": 7.0, "admin": true, "roles": []any{"a", "b"},
+		"n": 1.0, "u": 2.0, "f": 0.5, "took": 2.0, "at": "2023-07-14T21:08:20Z", "error": "EOF",
+		"b": "raw", "s": "const", "m": map[string]any{"x": 1.0},
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(m[k], v) {
+			t.Errorf("%s: got %v, want %v", k, m[k], v)
+		}
+	}
+	if _, ok := m["nil_err"]; ok {
+		t.Errorf("nil error written: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"level":"warn","user":"jerry","id":7,"admin":true,"extra":"1","msg":"builder"`) {
+		t.Errorf("got %s", lines[1])
+	}
+}
+
+// registered once per test binary, RegisterLevel panics on a second call
+const (
+	auditLevel = 1 << 7
+	eventLevel = 1 << 8
+)
+
+func init() {
+	RegisterLevel(auditLevel, LevelDef{Name: "audit", Rank: 45, Color: "\x1b[35m"})
+	RegisterLevel(eventLevel, LevelDef{Name: "event", Rank: 25, File: "info"})
+}
+
+func TestCustomLevels(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), TimeZone(time.UTC), TimeFormat(RFC3339Time), LevelValue(eventLevel, "EVENT"))
+	tl.Trace().Msg("trace is opt-in")
+	tl.WithLevel(InfoLevel | WarnLevel).Msg("not a level")
+	if w.buf.Len() != 0 {
+		t.Errorf("got %s", w.String())
+	}
+	tl.SetLevel(tl.Level() | TraceLevel)
+	tl.Trace().Msg("t")
+	tl.WithLevel(auditLevel).Str("user", "tom").Msg("login")
+	tl.Log(eventLevel, "n")
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	for i, want := range []string{`"level":"trace"`, `"level":"audit","user":"tom"`, `"level":"EVENT"`} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("got %s, want %s", lines[i], want)
+		}
+	}
+
+	w = &writeToBuffer{}
+	tl = New(SetWriter(w), Format(FormatConsole), ConsoleColor(true))
+	tl.WithLevel(auditLevel).Msg("login")
+	if !strings.Contains(w.String(), "\x1b[35mAUDIT\x1b[0m ") {
+		t.Errorf("got %q", w.String())
+	}
+
+	if ParseLevel("Audit") != auditLevel || LevelName(TraceLevel) != "trace" || ParseLevel("nope") != 0 {
+		t.Error("ParseLevel/LevelName")
+	}
+	if got, want := LevelsFrom(ErrorLevel), auditLevel|ErrorLevel|FatalLevel|PanicLevel; got != want {
+		t.Errorf("LevelsFrom: got %b, want %b", got, want)
+	}
+
+	dir := t.TempDir()
+	tl = New(SetWriter(NewWriteToFileSeparate(LogDir(dir), FileStoreMode(AppendOneFile))))
+	tl.Info().Msg("i")
+	tl.Log(eventLevel, "n")
+	tl.WithLevel(auditLevel).Msg("a")
+	for name, want := range map[string]int{"tlog-info.log": 2, "tlog-audit.log": 1} {
+		b, err := os.ReadFile(dir + "/" + name)
+		if err != nil || bytes.Count(b, []byte{'\n'}) != want {
+			t.Errorf("%s: %q %v", name, b, err)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	w := &writeToBuffer{}
+	tl := New(SetWriter(w), Format(FormatLogfmt), LevelOverrides("db=debug, db.pool=warn, *=info"))
+	db := tl.Named("db")
+	pool := db.Named("pool")
+	cache := tl.Named("cache")
+	if pool != tl.Named("db.pool") || pool.Name() != "db.pool" {
+		t.Fatal("Named")
+	}
+	db.Debug().Msg("d")
+	pool.Info().Msg("disabled")
+	pool.Warn().Msg("w")
+	cache.Debug().Msg("disabled")
+	tl.Info().Msg("root")
+	var got string
+	for _, line := range strings.SplitAfter(w.String(), "\n") {
+		if _, after, ok := strings.Cut(line, " level="); ok {
+			got += "level=" + after
+		}
+	}
+	want := "level=debug logger=db msg=d\nlevel=warn logger=db.pool msg=w\nlevel=info msg=root\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tl.SetLevelOverride("db.pool", LevelsFrom(DebugLevel))
+	tl.ClearLevelOverride("db")
+	if !pool.Enabled(DebugLevel) || db.Enabled(DebugLevel) || !db.Enabled(InfoLevel) {
+		t.Error("SetLevelOverride")
+	}
+	tl.SetLevel(LevelsFrom(ErrorLevel))
+	if tl.Enabled(WarnLevel) || cache.Enabled(WarnLevel) || !pool.Enabled(DebugLevel) {
+		t.Error("SetLevel")
+	}
+	tl.SetLevelOverrides(nil)
+	if cache.Enabled(TraceLevel) || !cache.Enabled(auditLevel) || len(tl.LevelOverrides()) != 0 {
+		t.Error("SetLevelOverrides")
+	}
+	lvl := tl.Named("new.child")
+	tl.SetLevelOverride("new", 0)
+	if lvl.Enabled(PanicLevel) {
+		t.Error("late override")
+	}
+
+	if _, err := ParseLevelOverrides("db=loud"); err == nil {
+		t.Error("bad level accepted")
+	}
+	if m, _ := ParseLevelOverrides(" x = off ,"); len(m) != 1 || m["x"] != 0 {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	tl := New(SetWriter(writeToDiscard{}), LevelOverrides("*=info"))
+	db := tl.Named("db")
+	srv := httptest.NewServer(tl.LevelHandler())
+	defer srv.Close()
+
+	do := func(method, body string) (int, map[string]any) {
+		req, _ := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var m map[string]any
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp.StatusCode, m
+	}
+	code, m := do("GET", "")
+	if code != 200 || m["level"] != "info" || !reflect.DeepEqual(m["loggers"], map[string]any{"db": "info"}) {
+		t.Fatalf("GET: %d %v", code, m)
+	}
+
+	code, m = do("PUT", `{"logger":"db","level":"debug","duration":"50ms"}`)
+	if code != 200 || !db.Enabled(DebugLevel) || m["expires"].(map[string]any)["db"] == nil {
+		t.Fatalf("PUT: %d %v", code, m)
+	}
+	for deadline := time.Now().Add(2 * time.Second); db.Enabled(DebugLevel); {
+		if time.Now().After(deadline) {
+			t.Fatal("temporary override not expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, m = do("GET", ""); m["expires"] != nil || m["overrides"].(map[string]any)["db"] != nil {
+		t.Errorf("after expiry: %v", m)
+	}
+
+	if code, m = do("PUT", `{"level":"warn|error"}`); code != 200 || m["level"] != "warn|error" || tl.Enabled(FatalLevel) {
+		t.Errorf("PUT mask: %d %v", code, m)
+	}
+	if code, _ = do("PUT", `{"level":"loud"}`); code != 400 {
+		t.Errorf("bad level: %d", code)
+	}
+	if code, _ = do("DELETE", ""); code != 405 {
+		t.Errorf("DELETE: %d", code)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := dir + "/tlog.json"
+	writeConfig := func(level, prefix string) {
+		cfg := `{"format":"logfmt","level":"` + level + `","time_format":"unix","reload":"10ms",
+			"writers":[{"type":"file","dir":"` + dir + `","prefix":"` + prefix + `","mode":"append"}],
+			"redact":[{"keys":["password"]}]}`
+		if err := os.WriteFile(cfgFile, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("db=debug, *=warn", "old")
+	tl, err := NewFromConfig(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Info().Msg("disabled")
+	tl.Named("db").Debug().Str("password", "secret").Msg("old")
+
+	// the modification time may not change within the same tick of the file system
+	time.Sleep(20 * time.Millisecond)
+	writeConfig("info", "new")
+	for deadline := time.Now().Add(2 * time.Second); !tl.Enabled(InfoLevel); {
+		if time.Now().After(deadline) {
+			t.Fatal("config not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	tl.Info().Msg("new")
+	oldLog, _ := os.ReadFile(dir + "/old.log")
+	newLog, _ := os.ReadFile(dir + "/new.log")
+	if !strings.HasSuffix(string(oldLog), " level=debug logger=db password=[REDACTED] msg=old\n") ||
+		strings.Count(string(oldLog), "\n") != 1 {
+		t.Errorf("old.log: %q", oldLog)
+	}
+	if !strings.HasSuffix(string(newLog), " level=info msg=new\n") {
+		t.Errorf("new.log: %q", newLog)
+	}
+	// Close doesn't wait for the watcher, the files below are in another dir
+	tl.Close()
+	dir = t.TempDir()
+	cfgFile = dir + "/tlog.json"
+
+	for _, cfg := range []string{`{"formats":"json"}`, `{"format":"xml"}`, `{"level":"loud"}`,
+		`{"writers":[{"type":"kafka"}]}`, `{"reload":"-1s"}`, `{"time_format":"rfc3399"}`} {
+		os.WriteFile(cfgFile, []byte(cfg), 0644)
+		if _, err := NewFromConfig(cfgFile); err == nil {
+			t.Errorf("%s: no error", cfg)
+		}
+	}
+
+	if _, err := (&Config{TimeFormat: "rfc3399"}).Options(); !errors.Is(err, ErrIllegalParam) {
+		t.Errorf("time_format typo: %v", err)
+	}
+	if _, err := (&Config{TimeFormat: "2006/01/02 15:04"}).Options(); err != nil {
+		t.Errorf("time_format layout: %v", err)
+	}
+
+	// the writer of the options wins, the config one isn't reloaded
+	writeConfig("info", "unused")
+	w := &writeToBuffer{}
+	tl2, err := NewFromConfig(cfgFile, SetWriter(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl2.Close()
+	if !strings.Contains(w.String(), "writers of the config are not reloaded") {
+		t.Errorf("no warning: %q", w.String())
+	}
+
+	t.Setenv("TLOG_LEVEL", "error")
+	RegisterConfigDecoder(".kv", func(data []byte, v any) error {
+		m := map[string]any{}
+		for _, line := range strings.Fields(string(data)) {
+			k, val, _ := strings.Cut(line, "=")
+			m[k] = val
+		}
+		*v.(*any) = m
+		return nil
+	})
+	os.WriteFile(dir+"/tlog.kv", []byte("format=console\nlevel=debug\n"), 0644)
+	if c, err := LoadConfig(dir + "/tlog.kv"); err != nil || c.Format != "console" || c.Level != "error" {
+		t.Errorf("LoadConfig: %+v %v", c, err)
+	}
+}
+
+func TestNewE(t *testing.T) {
+	_, err := NewE(Format(99))
+	var oe *OptionError
+	if !errors.Is(err, ErrIllegalParam) || !errors.As(err, &oe) || oe.Option != "Format" {
+		t.Errorf("NewE: %v", err)
+	}
+	// the options written as a func(*Options) still work, the first error wins
+	var custom Option = func(o *Options) { o.msgKey = "message" }
+	if _, err = NewE(custom, Format(99), TimeLayout("")); !errors.As(err, &oe) || oe.Option != "Format" {
+		t.Errorf("NewE: %v", err)
+	}
+	if _, err = NewE(LevelOverrides("db=loud")); err == nil || !strings.Contains(err.Error(), `unknown level "loud"`) {
+		t.Errorf("LevelOverrides: %v", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil || r.(error).Error() != "tlog:TimeLayout param is illegal" {
+				t.Errorf("New: %v", r)
+			}
+		}()
+		New(TimeLayout(""))
+	}()
+
+	// a dir that can't be created, even as root
+	notDir := t.TempDir() + "/file"
+	os.WriteFile(notDir, nil, 0644)
+	var pe *os.PathError
+	if _, err = OpenFileMixed(LogDir(notDir + "/logs")); !errors.As(err, &pe) {
+		t.Errorf("OpenFileMixed: %v", err)
+	}
+	if _, err = OpenFileSeparate(LogDir(notDir+"/logs"), FileStoreMode(AppendOneFile)); !errors.As(err, &pe) {
+		t.Errorf("OpenFileSeparate: %v", err)
+	}
+	if _, err = OpenSimplePost(); !errors.As(err, &oe) || oe.Option != "PostUrl" {
+		t.Errorf("OpenSimplePost: %v", err)
+	}
+	if _, err = OpenSplunkHEC(PostUrl("http://127.0.0.1:1")); !errors.As(err, &oe) || oe.Option != "HECToken" {
+		t.Errorf("OpenSplunkHEC: %v", err)
+	}
+
+	dir := t.TempDir()
+	w, err := OpenFileMixed(LogDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	New(SetWriter(w)).Info().Msg("x")
+	w.(*WriteToFileMixed).Close()
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("got %v", files)
+	}
+
+	// the first daily file is named after the day of the TimeZone
+	loc := time.FixedZone("east", 14*3600)
+	if time.Now().In(loc).Day() == time.Now().Day() {
+		loc = time.FixedZone("west", -12*3600)
+	}
+	dir = t.TempDir()
+	if w, err = OpenFileMixed(LogDir(dir), TimeZone(loc)); err != nil {
+		t.Fatal(err)
+	}
+	w.(*WriteToFileMixed).Close()
+	day := time.Now().In(loc).Format("2006-01-02")
+	if files, _ := os.ReadDir(dir); len(files) != 1 || !strings.Contains(files[0].Name(), day) {
+		t.Errorf("got %v, want %s", files, day)
+	}
+}
+
+type writeToFail struct{ err error }
+
+func (w writeToFail) Write(e Encoder, p []byte) (int, error) { return 0, w.err }
+
+type writeToShort struct{}
+
+func (w writeToShort) Write(e Encoder, p []byte) (int, error) { return len(p) / 2, nil }
+
+func TestErrorHandler(t *testing.T) {
+	fallback := &writeToBuffer{}
+	var calls, failed uint64
+	var lastErr error
+	handler := func(err error, n uint64) {
+		calls++
+		failed += n
+		lastErr = err
+	}
+	tl := New(SetWriter(writeToFail{io.ErrClosedPipe}), FallbackWriter(fallback), ErrorHandler(handler, time.Hour))
+	for i := 0; i < 3; i++ {
+		tl.Info().Int("i", i).Msg("x")
+	}
+	if calls != 1 || failed != 1 || lastErr != io.ErrClosedPipe {
+		t.Errorf("handler: %d calls, %d failed, %v", calls, failed, lastErr)
+	}
+	if s := tl.Stats(); s.WriteFailed != 3 || s.Lost != 0 || strings.Count(fallback.String(), "\n") != 3 {
+		t.Errorf("stats %+v, fallback %q", s, fallback.String())
+	}
+	tl.Close() // reports the pending failures
+	if calls != 2 || failed != 3 {
+		t.Errorf("Close: %d calls, %d failed", calls, failed)
+	}
+
+	// the failures within the interval are reported when it ends
+	var pending atomic.Uint64
+	tl = New(SetWriter(writeToShort{}), ErrorHandler(func(err error, n uint64) {
+		if errors.Is(err, io.ErrShortWrite) {
+			pending.Add(n)
+		}
+	}, 20*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		tl.Info().Msg("short")
+	}
+	for deadline := time.Now().Add(2 * time.Second); pending.Load() != 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d failures, want 3", pending.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := tl.Stats(); s.WriteFailed != 3 || s.Lost != 3 {
+		t.Errorf("short writes: %+v", s)
+	}
+
+	// the error of reopening a file is returned instead of writing to fd -1
+	dir := t.TempDir()
+	w, err := OpenFileSeparate(LogDir(dir+"/logs"), FileStoreMode(AppendOneFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls, failed = 0, 0
+	tl = New(SetWriter(w), ErrorHandler(handler, 0))
+	os.RemoveAll(dir + "/logs")
+	os.WriteFile(dir+"/logs", nil, 0644)
+	tl.Warn().Msg("lost")
+	tl.Warn().Msg("lost")
+	var pe *os.PathError
+	if calls != 2 || failed != 2 || !errors.As(lastErr, &pe) || tl.Stats().Lost != 2 {
+		t.Errorf("handler: %d calls, %d failed, %v, %+v", calls, failed, lastErr, tl.Stats())
+	}
+}
@@ -0,0 +1,115 @@
+package tlog
+
+import (
+	"context"
+	"errors"
+)
+
+// SpanContext identifies a span as in the W3C traceparent header, e.g. the
+// span context of OpenTelemetry (trace.SpanContext):
+//
+//	sc := trace.SpanContextFromContext(ctx)
+//	tlog.SpanContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), TraceFlags: byte(sc.TraceFlags())}
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	TraceFlags byte
+}
+
+// IsValid returns true if both IDs are non-zero
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+var errTraceparent = errors.New("tlog: invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent header,
+// e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' ||
+		(len(s) > 55 && s[55] != '-') || s[:2] == "ff" {
+		return sc, errTraceparent
+	}
+	var version [1]byte
+	if !parseHex(version[:], s[0:2]) || (version[0] == 0 && len(s) != 55) ||
+		!parseHex(sc.TraceID[:], s[3:35]) ||
+		!parseHex(sc.SpanID[:], s[36:52]) {
+		return sc, errTraceparent
+	}
+	var flags [1]byte
+	if !parseHex(flags[:], s[53:55]) || !sc.IsValid() {
+		return sc, errTraceparent
+	}
+	sc.TraceFlags = flags[0]
+	return sc, nil
+}
+
+// Lower case only, as required by the W3C spec
+func parseHex(dst []byte, s string) bool {
+	for i := range dst {
+		hi, ok1 := unhex(s[i*2])
+		lo, ok2 := unhex(s[i*2+1])
+		if !ok1 || !ok2 {
+			return false
+		}
+		dst[i] = hi<<4 | lo
+	}
+	return true
+}
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
+}
+
+type spanCtxKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc, for the default
+// ExtractTrace
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanCtxKey{}, sc)
+}
+
+// SpanContextFromContext returns the SpanContext set by ContextWithSpanContext
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanCtxKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// SpanContextFunc returns the span of ctx, false if there's none
+type SpanContextFunc func(ctx context.Context) (SpanContext, bool)
+
+// ExtractTrace adds the trace_id, span_id and trace_flags fields (lower case
+// hex) of the span of ctx. f adapts the tracing library, e.g. OpenTelemetry,
+// SpanContextFromContext is used if f is nil.
+func ExtractTrace(f SpanContextFunc) ContextExtractor {
+	if f == nil {
+		f = SpanContextFromContext
+	}
+	return func(ctx context.Context, e Encoder) {
+		sc, ok := f(ctx)
+		if !ok {
+			return
+		}
+		if te, ok := e.(traceEncoder); ok {
+			te.appendTrace(sc)
+		}
+	}
+}
+
+// Implemented by the encoders, sc is passed by value so the IDs don't escape
+// to the heap
+type traceEncoder interface {
+	appendTrace(sc SpanContext)
+}
+
+func (e *encoder) appendHex(b []byte) {
+	for _, c := range b {
+		e.buf = append(e.buf, hex[c>>4], hex[c&0xf])
+	}
+}
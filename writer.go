@@ -1,5 +1,10 @@
 package tlog
 
+import (
+	"io"
+	"sync"
+)
+
 type FileStoreModeT int
 
 const (
@@ -10,3 +15,64 @@ const (
 type Writer interface {
 	Write(e Encoder, p []byte) (n int, err error)
 }
+
+// multiWriter writes to every writer, the first error is returned
+type multiWriter []Writer
+
+func (ws multiWriter) Write(e Encoder, p []byte) (n int, err error) {
+	for _, w := range ws {
+		if _, werr := w.Write(e, p); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return len(p), err
+}
+
+// Close closes every writer, the first error is returned
+func (ws multiWriter) Close() error {
+	var err error
+	for _, w := range ws {
+		if cerr := closeWriter(w); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// swapWriter lets the writer be replaced while lines are written, see
+// NewFromConfig. The old writer is closed once its writes are done.
+type swapWriter struct {
+	mtx sync.RWMutex
+	w   Writer
+}
+
+// Write writes to the current writer, swap waits for it
+func (s *swapWriter) Write(e Encoder, p []byte) (n int, err error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.w.Write(e, p)
+}
+
+// swap replaces the writer by w and closes the old one
+func (s *swapWriter) swap(w Writer) {
+	s.mtx.Lock()
+	old := s.w
+	s.w = w
+	s.mtx.Unlock()
+	closeWriter(old)
+}
+
+// Close closes the current writer
+func (s *swapWriter) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return closeWriter(s.w)
+}
+
+// closeWriter closes w if it's an io.Closer
+func closeWriter(w Writer) error {
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
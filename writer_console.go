@@ -16,3 +16,16 @@ func (w *WriteToConsole) Write(e Encoder, p []byte) (n int, err error) {
 	defer w.mtx.Unlock()
 	return os.Stdout.Write(p)
 }
+
+// WriteToStderr writes to os.Stderr, e.g. as a FallbackWriter
+type WriteToStderr struct {
+	mtx sync.Mutex
+}
+
+func NewWriteToStderr() *WriteToStderr { return &WriteToStderr{} }
+
+func (w *WriteToStderr) Write(e Encoder, p []byte) (n int, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return os.Stderr.Write(p)
+}
@@ -1,12 +1,12 @@
 package tlog
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"path"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type WriteToFileMixed struct {
@@ -21,9 +21,25 @@ type WriteToFileMixed struct {
 	mtx sync.Mutex
 }
 
+// NewWriteToFileMixed writes all levels to one file, it panics if
+// OpenFileMixed fails
 func NewWriteToFileMixed(opts ...Option) Writer {
-	opt := setOptions(opts...)
+	w, err := OpenFileMixed(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
 
+// OpenFileMixed is NewWriteToFileMixed returning an *OptionError or the
+// *os.PathError of the log dir or file (e.g. a read-only mount) instead of
+// panicking. The file of the day is opened at once in DailySplit mode too,
+// pass the TimeZone option of the logger to name it after the day of the lines.
+func OpenFileMixed(opts ...Option) (Writer, error) {
+	opt, err := setOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
 	w := &WriteToFileMixed{
 		fd:            -1,
 		dir:           opt.logDir,
@@ -32,15 +48,23 @@ func NewWriteToFileMixed(opts ...Option) Writer {
 	}
 	if w.dir != "" {
 		if err := os.MkdirAll(w.dir, 0755); err != nil {
-			panic(errors.New("newlog mkdir fail! " + err.Error()))
+			return nil, err
 		}
 	}
 	if w.fileStoreMode == AppendOneFile {
-		if err := w.openAppendFile(); err != nil {
-			panic(errors.New("newlog open file fail! " + err.Error()))
+		err = w.openAppendFile()
+	} else {
+		now := time.Now()
+		if opt.location != nil { // the day of the lines, see TimeZone
+			now = now.In(opt.location)
 		}
+		year, month, day := now.Date()
+		err = w.newFile(year, int(month), day)
 	}
-	return w
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 func (w *WriteToFileMixed) Write(e Encoder, p []byte) (n int, err error) {
 	now := e.Now()
@@ -53,6 +77,10 @@ func (w *WriteToFileMixed) Write(e Encoder, p []byte) (n int, err error) {
 		if err = w.newFile(year, int(month), day); err != nil {
 			return
 		}
+	} else if w.fd == -1 { // closed
+		if err = w.openAppendFile(); err != nil {
+			return
+		}
 	}
 	for {
 		n, err = syscall.Write(w.fd, p)
@@ -86,7 +114,7 @@ func (w *WriteToFileMixed) openAppendFile() (err error) {
 			if err == syscall.EINTR {
 				continue
 			}
-			return err
+			return &os.PathError{Op: "open", Path: logFile, Err: err}
 		}
 		break
 	}
@@ -106,13 +134,22 @@ func (w *WriteToFileMixed) openSeparateFile(year, month, day int) (err error) {
 			if err == syscall.EINTR {
 				continue
 			}
-			return err
+			return &os.PathError{Op: "open", Path: logFile, Err: err}
 		}
 		break
 	}
 	w.newFileYear, w.newFileMonth, w.newFileDay = year, month, day
 	return nil
 }
+
+// Close closes the file, it's reopened by the next Write
+func (w *WriteToFileMixed) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.close()
+	w.newFileYear = 0
+	return nil
+}
 func (w *WriteToFileMixed) close() {
 	if w.fd != -1 {
 		syscall.Close(w.fd)
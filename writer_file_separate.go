@@ -1,7 +1,6 @@
 package tlog
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -9,85 +8,90 @@ import (
 	"syscall"
 )
 
+// WriteToFileSeparate writes each level to its own file, named by
+// LevelDef.File, the levels of the same file share it.
 type WriteToFileSeparate struct {
-	debugWriter writeToFileSeparateLevel
-	infoWriter  writeToFileSeparateLevel
-	warnWriter  writeToFileSeparateLevel
-	errorWriter writeToFileSeparateLevel
-	fatalWriter writeToFileSeparateLevel
-	panicWriter writeToFileSeparateLevel
+	writers [maxLevels]*writeToFileSeparateLevel // indexed by level bit
 }
 
+// NewWriteToFileSeparate writes each level to its own file, it panics if
+// OpenFileSeparate fails
 func NewWriteToFileSeparate(opts ...Option) Writer {
-	opt := setOptions(opts...)
+	w, err := OpenFileSeparate(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
 
-	w := &WriteToFileSeparate{
-		debugWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "debug",
-			fileStoreMode: opt.fileStoreMode,
-		},
-		infoWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "info",
-			fileStoreMode: opt.fileStoreMode,
-		},
-		warnWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "warn",
-			fileStoreMode: opt.fileStoreMode,
-		},
-		errorWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "error",
-			fileStoreMode: opt.fileStoreMode,
-		},
-		fatalWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "fatal",
-			fileStoreMode: opt.fileStoreMode,
-		},
-		panicWriter: writeToFileSeparateLevel{
-			fd:            -1,
-			dir:           opt.logDir,
-			logFilePrefix: opt.logFilePrefix,
-			name:          "panic",
-			fileStoreMode: opt.fileStoreMode,
-		},
+// OpenFileSeparate is NewWriteToFileSeparate returning an *OptionError or
+// the *os.PathError of the log dir (e.g. a read-only mount) instead of
+// panicking. The files are opened by the first line of their level, the dir
+// is checked to be writable at once.
+func OpenFileSeparate(opts ...Option) (Writer, error) {
+	opt, err := setOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WriteToFileSeparate{}
+	files := make(map[string]*writeToFileSeparateLevel)
+	for i, d := range levelDefs() {
+		if len(d.Name) == 0 {
+			continue
+		}
+		name := d.File
+		if len(name) == 0 {
+			name = d.Name
+		}
+		lw, ok := files[name]
+		if !ok {
+			lw = &writeToFileSeparateLevel{
+				fd:            -1,
+				dir:           opt.logDir,
+				logFilePrefix: opt.logFilePrefix,
+				name:          name,
+				fileStoreMode: opt.fileStoreMode,
+			}
+			files[name] = lw
+		}
+		w.writers[i] = lw
 	}
 	if opt.logDir != "" {
 		if err := os.MkdirAll(opt.logDir, 0755); err != nil {
-			panic(errors.New("newlog mkdir fail! " + err.Error()))
+			return nil, err
 		}
 	}
-	return w
+	dir := opt.logDir
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, ".tlog-*")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return w, nil
 }
 func (w *WriteToFileSeparate) Write(e Encoder, p []byte) (n int, err error) {
-	switch e.Level() {
-	case DebugLevel:
-		return w.debugWriter.Write(e, p)
-	case InfoLevel:
-		return w.infoWriter.Write(e, p)
-	case WarnLevel:
-		return w.warnWriter.Write(e, p)
-	case ErrorLevel:
-		return w.errorWriter.Write(e, p)
-	case FatalLevel:
-		return w.fatalWriter.Write(e, p)
-	case PanicLevel:
-		return w.panicWriter.Write(e, p)
-	}
-	return 0, nil
+	if i := levelIndex(e.Level()); i < len(w.writers) && w.writers[i] != nil {
+		return w.writers[i].Write(e, p)
+	}
+	return len(p), nil // no file for the level, not a failure
+}
+
+// Close closes the files, they're reopened by the next Write
+func (w *WriteToFileSeparate) Close() error {
+	for _, lw := range w.writers {
+		if lw != nil {
+			lw.mtx.Lock()
+			lw.close()
+			lw.newFileYear = 0
+			lw.mtx.Unlock()
+		}
+	}
+	return nil
 }
 
 type writeToFileSeparateLevel struct {
@@ -115,7 +119,9 @@ func (w *writeToFileSeparateLevel) Write(e Encoder, p []byte) (n int, err error)
 			return
 		}
 	} else if w.fd == -1 {
-		w.openAppendFile()
+		if err = w.openAppendFile(); err != nil {
+			return
+		}
 	}
 	for {
 		n, err = syscall.Write(w.fd, p)
@@ -149,7 +155,7 @@ func (w *writeToFileSeparateLevel) openAppendFile() (err error) {
 			if err == syscall.EINTR {
 				continue
 			}
-			return err
+			return &os.PathError{Op: "open", Path: logFile, Err: err}
 		}
 		break
 	}
@@ -169,7 +175,7 @@ func (w *writeToFileSeparateLevel) openSeparateFile(year, month, day int) (err e
 			if err == syscall.EINTR {
 				continue
 			}
-			return err
+			return &os.PathError{Op: "open", Path: logFile, Err: err}
 		}
 		break
 	}
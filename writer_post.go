@@ -2,38 +2,68 @@ package tlog
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"sync"
 	"time"
 )
 
 type WriteToSimplePost struct {
-	mtx sync.Mutex
-	url string
+	mtx    sync.Mutex
+	url    string
+	client *http.Client
 }
 
+// NewWriteToSimplePost panics if OpenSimplePost fails
 func NewWriteToSimplePost(opts ...Option) *WriteToSimplePost {
-	opt := setOptions(opts...)
+	w, err := OpenSimplePost(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// OpenSimplePost is NewWriteToSimplePost returning an *OptionError instead
+// of panicking, PostUrl is required
+func OpenSimplePost(opts ...Option) (*WriteToSimplePost, error) {
+	opt, err := setOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
 	if len(opt.postUrl) == 0 {
-		panic("newlog simple post, post url is empty")
+		return nil, optionError("PostUrl")
 	}
-	return &WriteToSimplePost{url: opt.postUrl}
+	return &WriteToSimplePost{
+		url:    opt.postUrl,
+		client: &http.Client{Timeout: 3000 * time.Millisecond},
+	}, nil
 }
 
 func (w *WriteToSimplePost) Write(e Encoder, p []byte) (n int, err error) {
-	client := &http.Client{Timeout: 3000 * time.Millisecond}
-	buffer := bytes.NewBuffer(p)
-	request, err := http.NewRequest("POST", w.url, buffer)
+	_, _, err = httpPost(w.client, w.url, p, "application/json", nil)
 	if err != nil {
 		return 0, err
 	}
-	request.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(request)
-	if err != nil && resp == nil {
-		return 0, err
+	return len(p), nil
+}
+
+// httpPost sends body to url and returns the status code and at most 64KiB
+// of the response body. headers are extra request headers as key/value pairs.
+func httpPost(client *http.Client, url string, body []byte, contentType string,
+	headers []string) (code int, resp []byte, err error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
 	}
-	if resp != nil {
-		defer resp.Body.Close()
+	request.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
 	}
-	return len(p), nil
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	resp, _ = io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	return response.StatusCode, resp, nil
 }
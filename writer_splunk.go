@@ -0,0 +1,362 @@
+package tlog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// WriteToSplunkHEC sends log lines to a Splunk HTTP Event Collector.
+//
+// Every line is wrapped as {"time":..,"host":..,"source":..,"sourcetype":..,"event":{...}}
+// and several events are batched into one request. When a channel is set
+// (HECChannel) the indexer acknowledgment protocol is used, batches which are
+// not acknowledged within HECAckTimeout are sent again. Batches which fail to
+// be sent are queued and sent again by the next flush, up to hecMaxRetries
+// times.
+type WriteToSplunkHEC struct {
+	url      string
+	ackUrl   string
+	auth     string
+	channel  string
+	meta     []byte // ,"host":"..","source":"..","sourcetype":"..","event":
+	maxCount int
+
+	ackTimeout time.Duration
+	client     *http.Client
+
+	mtx   sync.Mutex
+	buf   []byte
+	count int
+
+	ackMtx  sync.Mutex // for pending and retry
+	pending map[int64]*hecBatch
+	retry   []*hecBatch // to be sent again by Flush
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type hecBatch struct {
+	data    []byte
+	sentAt  time.Time
+	retries int
+}
+
+const (
+	hecMaxBatchBytes = 1 << 20 // 1MiB
+	hecMaxRetries    = 3
+	hecMaxPending    = 1024 // batches waiting for an acknowledgment
+	hecMaxRetry      = 64   // batches queued to be sent again
+)
+
+// NewWriteToSplunkHEC panics if OpenSplunkHEC fails
+func NewWriteToSplunkHEC(opts ...Option) *WriteToSplunkHEC {
+	w, err := OpenSplunkHEC(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// OpenSplunkHEC is NewWriteToSplunkHEC returning an *OptionError instead of
+// panicking, PostUrl and HECToken are required
+func OpenSplunkHEC(opts ...Option) (*WriteToSplunkHEC, error) {
+	opt, err := setOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.postUrl) == 0 {
+		return nil, optionError("PostUrl")
+	}
+	if len(opt.hecToken) == 0 {
+		return nil, optionError("HECToken")
+	}
+	host := opt.hecHost
+	if len(host) == 0 {
+		host, _ = os.Hostname()
+	}
+	w := &WriteToSplunkHEC{
+		url:        opt.postUrl,
+		auth:       "Splunk " + opt.hecToken,
+		channel:    opt.hecChannel,
+		maxCount:   opt.hecBatchSize,
+		ackTimeout: opt.hecAckTimeout,
+		client:     &http.Client{Timeout: 3000 * time.Millisecond},
+		buf:        make([]byte, 0, 4096),
+		pending:    make(map[int64]*hecBatch),
+		done:       make(chan struct{}),
+	}
+	if len(w.channel) > 0 {
+		w.ackUrl = hecAckUrl(w.url)
+	}
+
+	enc := encoder{buf: make([]byte, 0, 128)}
+	for _, kv := range [][2]string{{"host", host}, {"source", opt.hecSource}, {"sourcetype", opt.hecSourceType}} {
+		if len(kv[1]) == 0 {
+			continue
+		}
+		enc.buf = append(enc.buf, ',', '"')
+		enc.fastAppendString(kv[0])
+		enc.buf = append(enc.buf, '"', ':', '"')
+		enc.appendString(kv[1])
+		enc.buf = append(enc.buf, '"')
+	}
+	enc.buf = append(enc.buf, `,"event":`...)
+	w.meta = enc.buf
+
+	w.wg.Add(1)
+	go w.loop(opt.hecFlushInterval)
+	return w, nil
+}
+
+func (w *WriteToSplunkHEC) Write(e Encoder, p []byte) (n int, err error) {
+	n = len(p)
+	if len(p) > 0 && p[len(p)-1] == '\n' {
+		p = p[:len(p)-1]
+	}
+	now := e.Now()
+
+	w.mtx.Lock()
+	w.buf = append(w.buf, `{"time":`...)
+	w.buf = strconv.AppendInt(w.buf, now.Unix(), 10)
+	w.buf = append(w.buf, '.')
+	ms := now.Nanosecond() / 1e6
+	w.buf = append(w.buf, byte('0'+ms/100), byte('0'+ms/10%10), byte('0'+ms%10))
+	w.buf = append(w.buf, w.meta...)
+	if len(p) > 0 && p[0] == '{' {
+		w.buf = append(w.buf, p...)
+	} else {
+		// text format, send the line as a string event
+		enc := encoder{buf: w.buf}
+		enc.buf = append(enc.buf, '"')
+		enc.appendString(*(*string)(unsafe.Pointer(&p))) // p isn't kept
+		enc.buf = append(enc.buf, '"')
+		w.buf = enc.buf
+	}
+	w.buf = append(w.buf, '}', '\n')
+	w.count++
+
+	var batch []byte
+	if w.count >= w.maxCount || len(w.buf) >= hecMaxBatchBytes {
+		batch = w.takeBatch()
+	}
+	w.mtx.Unlock()
+
+	if batch != nil {
+		if err = w.send(&hecBatch{data: batch}); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush sends the buffered events and the batches queued after a failure
+// immediately, it returns an error if a batch is dropped.
+func (w *WriteToSplunkHEC) Flush() (err error) {
+	w.mtx.Lock()
+	batch := w.takeBatch()
+	w.mtx.Unlock()
+	if batch != nil {
+		err = w.send(&hecBatch{data: batch})
+	}
+
+	w.ackMtx.Lock()
+	retry := w.retry
+	w.retry = nil
+	w.ackMtx.Unlock()
+	for _, b := range retry {
+		if serr := w.send(b); serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Close flushes the buffered events and stops the background goroutine.
+func (w *WriteToSplunkHEC) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.wg.Wait()
+	})
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.ackMtx.Lock()
+	defer w.ackMtx.Unlock()
+	if len(w.retry) > 0 {
+		return errors.New("splunk hec: " + strconv.Itoa(len(w.retry)) + " batches not sent")
+	}
+	return nil
+}
+
+// PendingAcks returns the number of batches waiting for an indexer acknowledgment.
+func (w *WriteToSplunkHEC) PendingAcks() int {
+	w.ackMtx.Lock()
+	defer w.ackMtx.Unlock()
+	return len(w.pending)
+}
+
+// must hold w.mtx
+func (w *WriteToSplunkHEC) takeBatch() []byte {
+	if w.count == 0 {
+		return nil
+	}
+	batch := make([]byte, len(w.buf))
+	copy(batch, w.buf)
+	w.buf = w.buf[:0]
+	w.count = 0
+	return batch
+}
+
+func (w *WriteToSplunkHEC) headers() []string {
+	if len(w.channel) == 0 {
+		return []string{"Authorization", w.auth}
+	}
+	return []string{"Authorization", w.auth, "X-Splunk-Request-Channel", w.channel}
+}
+
+// send posts b, if it fails b is queued to be sent again. The error is
+// returned only if b is dropped.
+func (w *WriteToSplunkHEC) send(b *hecBatch) error {
+	ackId, err := w.post(b.data)
+	w.ackMtx.Lock()
+	defer w.ackMtx.Unlock()
+	if err != nil {
+		if w.requeue(b) {
+			return nil
+		}
+		return err
+	}
+	if ackId != nil {
+		if len(w.pending) >= hecMaxPending {
+			w.evictOldestPending()
+		}
+		b.sentAt = time.Now()
+		w.pending[*ackId] = b
+	}
+	return nil
+}
+
+// post sends the batch, it returns the ackId if the channel is set
+func (w *WriteToSplunkHEC) post(batch []byte) (*int64, error) {
+	code, body, err := httpPost(w.client, w.url, batch, "application/json", w.headers())
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Text  string `json:"text"`
+		Code  int    `json:"code"`
+		AckId *int64 `json:"ackId"`
+	}
+	json.Unmarshal(body, &resp)
+	if code != http.StatusOK || resp.Code != 0 {
+		return nil, errors.New("splunk hec: " + strconv.Itoa(code) + " " + resp.Text)
+	}
+	if len(w.channel) == 0 {
+		return nil, nil
+	}
+	return resp.AckId, nil
+}
+
+// requeue queues b to be sent again, it returns false if b is dropped
+// because it was sent too many times or the queue is full. must hold w.ackMtx
+func (w *WriteToSplunkHEC) requeue(b *hecBatch) bool {
+	if b.retries >= hecMaxRetries || len(w.retry) >= hecMaxRetry {
+		return false
+	}
+	b.retries++
+	w.retry = append(w.retry, b)
+	return true
+}
+
+// evictOldestPending gives up waiting for the acknowledgment of the oldest
+// pending batch, it's sent again. must hold w.ackMtx
+func (w *WriteToSplunkHEC) evictOldestPending() {
+	var oldest int64
+	var ob *hecBatch
+	for id, b := range w.pending {
+		if ob == nil || b.sentAt.Before(ob.sentAt) {
+			oldest, ob = id, b
+		}
+	}
+	delete(w.pending, oldest)
+	w.requeue(ob)
+}
+
+func (w *WriteToSplunkHEC) loop(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.Flush()
+			if len(w.channel) > 0 {
+				w.checkAcks()
+			}
+		}
+	}
+}
+
+func (w *WriteToSplunkHEC) checkAcks() {
+	w.ackMtx.Lock()
+	if len(w.pending) == 0 {
+		w.ackMtx.Unlock()
+		return
+	}
+	req := struct {
+		Acks []int64 `json:"acks"`
+	}{Acks: make([]int64, 0, len(w.pending))}
+	for id := range w.pending {
+		req.Acks = append(req.Acks, id)
+	}
+	w.ackMtx.Unlock()
+
+	// if the ack request fails, no batch is acknowledged but the timed out
+	// ones are still sent again
+	var resp struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	body, _ := json.Marshal(&req)
+	code, data, err := httpPost(w.client, w.ackUrl, body, "application/json", w.headers())
+	if err == nil && code == http.StatusOK {
+		json.Unmarshal(data, &resp)
+	}
+
+	now := time.Now()
+	w.ackMtx.Lock()
+	defer w.ackMtx.Unlock()
+	for _, id := range req.Acks {
+		b, ok := w.pending[id]
+		if !ok {
+			continue
+		}
+		if resp.Acks[strconv.FormatInt(id, 10)] {
+			delete(w.pending, id)
+		} else if now.Sub(b.sentAt) > w.ackTimeout {
+			delete(w.pending, id)
+			w.requeue(b)
+		}
+	}
+}
+
+// http://host:8088/services/collector/event => http://host:8088/services/collector/ack
+func hecAckUrl(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	u.Path = "/services/collector/ack"
+	u.RawQuery = ""
+	return u.String()
+}